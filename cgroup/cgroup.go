@@ -7,17 +7,99 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/sunweiwe/container/utils"
 )
 
+// IsCGroupV2 reports whether the host uses the unified cgroup v2
+// hierarchy instead of one hierarchy per controller.
+func IsCGroupV2() bool {
+	_, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	return err == nil
+}
+
 func getCgroups(containerId string) []string {
+	if IsCGroupV2() {
+		return []string{"/sys/fs/cgroup/container/" + containerId}
+	}
 
-	return []string{
+	cgroups := []string{
 		"/sys/fs/cgroup/memory/container/" + containerId,
 		"/sys/fs/cgroup/cpu/container/" + containerId,
-		"/sys/fs/cgroup/pid/container/" + containerId,
+		"/sys/fs/cgroup/pids/container/" + containerId,
+		"/sys/fs/cgroup/freezer/container/" + containerId,
+	}
+	// Only stats needs these, so do without them where they are not
+	// mounted.
+	for _, controller := range []string{"cpuacct", "blkio"} {
+		if hasV1Controller(controller) {
+			cgroups = append(cgroups, "/sys/fs/cgroup/"+controller+"/container/"+containerId)
+		}
+	}
+	return cgroups
+}
+
+// hasV1Controller reports whether the cgroup v1 hierarchy of controller
+// is mounted.
+func hasV1Controller(controller string) bool {
+	_, err := os.Stat("/sys/fs/cgroup/" + controller + "/cgroup.procs")
+	return err == nil
+}
+
+// GetCGroupPaths returns the container's cgroup directories, one per
+// controller on cgroup v1 or the single unified one on v2.
+func GetCGroupPaths(containerId string) []string {
+	return getCgroups(containerId)
+}
+
+// SetupCGroups creates the container's cgroup directories without moving
+// any process into them.
+func SetupCGroups(containerId string) error {
+	if IsCGroupV2() {
+		if err := enableControllers(); err != nil {
+			return err
+		}
+	}
+	if err := utils.CreateDirsIfDontExist(getCgroups(containerId)); err != nil {
+		return fmt.Errorf("unable to create cgroup directories: %v", err)
+	}
+	return nil
+}
+
+// v2Controllers are the controllers containers use on cgroup v2.
+var v2Controllers = []string{"cpu", "memory", "pids", "io"}
+
+// enableControllers makes the v2 controllers available to the cgroups of
+// containers. On v2 a cgroup only gets the controllers its parent lists
+// in cgroup.subtree_control, so they have to be switched on in the root
+// and in our own parent group. Controllers the kernel does not offer are
+// skipped.
+func enableControllers() error {
+	parent := "/sys/fs/cgroup/container"
+	if err := utils.CreateDirsIfDontExist([]string{parent}); err != nil {
+		return fmt.Errorf("unable to create cgroup directory: %v", err)
+	}
+	for _, dir := range []string{"/sys/fs/cgroup", parent} {
+		data, err := os.ReadFile(dir + "/cgroup.controllers")
+		if err != nil {
+			return err
+		}
+		available := strings.Fields(string(data))
+		for _, controller := range v2Controllers {
+			found := false
+			for _, c := range available {
+				found = found || c == controller
+			}
+			if !found {
+				continue
+			}
+			if err := os.WriteFile(dir+"/cgroup.subtree_control", []byte("+"+controller), 0644); err != nil {
+				return fmt.Errorf("unable to enable the %s controller in %s: %v", controller, dir, err)
+			}
+		}
 	}
+	return nil
 }
 
 // 原理？
@@ -46,38 +128,76 @@ func CreateCGroups(containerId string, createCGroupDirs bool) {
 	}
 }
 
-func RemoveCGroups(containerId string) {
+// GetCGroupPids returns the PIDs of every process in the container's
+// cgroup, as seen from the host.
+func GetCGroupPids(containerId string) ([]int, error) {
+	var pids []int
+	// Every controller holds the same set of processes, any one will do.
+	data, err := os.ReadFile(getCgroups(containerId)[0] + "/cgroup.procs")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// RemoveCGroups removes the container's cgroup directories. Directories
+// that are already gone are skipped.
+func RemoveCGroups(containerId string) error {
 	cgroups := getCgroups(containerId)
 
 	for _, cgroup := range cgroups {
-		utils.DoOrDieWithMessage(os.Remove(cgroup), "Unable to remove cgroup dir")
+		if err := os.Remove(cgroup); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove cgroup dir %s: %v", cgroup, err)
+		}
 	}
+	return nil
 }
 
-func ConfigureCGroups(containerId string, memory int, swap int, pids int, cpus float64) {
+func ConfigureCGroups(containerId string, memory int, swap int, pids int, cpus float64) error {
 
 	if memory > 0 {
-		setMemoryLimit(containerId, memory, swap)
+		if err := setMemoryLimit(containerId, memory, swap); err != nil {
+			return err
+		}
 	}
 
 	if cpus > 0 {
-		setCpuLimit(containerId, cpus)
+		if err := setCpuLimit(containerId, cpus); err != nil {
+			return err
+		}
 	}
 
 	if pids > 0 {
-		setPidsLimit(containerId, pids)
+		if err := setPidsLimit(containerId, pids); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func setMemoryLimit(containerId string, memory int, swap int) {
+func setMemoryLimit(containerId string, memory int, swap int) error {
+	if IsCGroupV2() {
+		return setMemoryLimitV2(containerId, memory, swap)
+	}
 	memoryFilePath := "/sys/fs/cgroup/memory/container/" + containerId +
 		"/memory.limit_in_bytes"
 	swapFilePath := "/sys/fs/cgroup/memory/container/" + containerId +
 		"/memory.memsw.limit_in_bytes"
 
-	utils.DoOrDieWithMessage(os.WriteFile(memoryFilePath,
-		[]byte(strconv.Itoa(memory*1024*1024)), 0644),
-		"Unable to write memory limit")
+	writeMemory := func() error {
+		if err := os.WriteFile(memoryFilePath,
+			[]byte(strconv.Itoa(memory*1024*1024)), 0644); err != nil {
+			return fmt.Errorf("unable to write memory limit: %v", err)
+		}
+		return nil
+	}
 
 	/*
 		memory.memsw.limit_in_bytes contains the total amount of memory the
@@ -86,34 +206,118 @@ func setMemoryLimit(containerId string, memory int, swap int) {
 		is left untouched, processes in the control group will continue to
 		consume swap space.
 	*/
+	if swap < 0 {
+		return writeMemory()
+	}
+	total := (memory * 1024 * 1024) + (swap * 1024 * 1024)
+	writeSwap := func() error {
+		if err := os.WriteFile(swapFilePath, []byte(strconv.Itoa(total)), 0644); err != nil {
+			return fmt.Errorf("unable to write memory limit: %v", err)
+		}
+		return nil
+	}
+
+	// The kernel keeps memsw at or above the memory limit, so when a
+	// running container's memory grows past its old memsw, memsw has to
+	// go first.
+	if current, err := readUint(swapFilePath); err == nil && uint64(memory*1024*1024) > current {
+		if err := writeSwap(); err != nil {
+			return err
+		}
+		return writeMemory()
+	}
+	if err := writeMemory(); err != nil {
+		return err
+	}
+	return writeSwap()
+}
+
+// setMemoryLimitV2 sets the limits on cgroup v2, where swap has a limit
+// of its own rather than one on memory and swap together.
+func setMemoryLimitV2(containerId string, memory int, swap int) error {
+	cgroupDir := "/sys/fs/cgroup/container/" + containerId
+	if err := os.WriteFile(cgroupDir+"/memory.max", []byte(strconv.Itoa(memory*1024*1024)), 0644); err != nil {
+		return fmt.Errorf("unable to write memory limit: %v", err)
+	}
 	if swap >= 0 {
-		utils.DoOrDieWithMessage(
-			os.WriteFile(swapFilePath, []byte(strconv.Itoa((memory*1024*1024)+(swap*1024*1024))), 0644),
-			"Unable to write memory limit",
-		)
+		if err := os.WriteFile(cgroupDir+"/memory.swap.max", []byte(strconv.Itoa(swap*1024*1024)), 0644); err != nil {
+			return fmt.Errorf("unable to write swap limit: %v", err)
+		}
 	}
+	return nil
 }
 
-func setCpuLimit(containerId string, cpus float64) {
+func setCpuLimit(containerId string, cpus float64) error {
+	if cpus > float64(runtime.NumCPU()) {
+		fmt.Printf("Ignoring attempt to set CPU quota to great than number of available CPUs")
+		return nil
+	}
+	if IsCGroupV2() {
+		// cpu.max holds the quota and the period together.
+		cpuMax := strconv.Itoa(int(1000000*cpus)) + " 1000000"
+		if err := os.WriteFile("/sys/fs/cgroup/container/"+containerId+"/cpu.max", []byte(cpuMax), 0644); err != nil {
+			return fmt.Errorf("unable to write CPU limit: %v", err)
+		}
+		return nil
+	}
+
 	cfsPeriodPath := "/sys/fs/cgroup/cpu/container/" + containerId +
 		"/cpu.cfs_period_us"
 	cfsQuotaPath := "/sys/fs/cgroup/cpu/container/" + containerId +
 		"/cpu.cfs_quota_us"
 
-	if cpus > float64(runtime.NumCPU()) {
-		fmt.Printf("Ignoring attempt to set CPU quota to great than number of available CPUs")
-		return
+	if err := os.WriteFile(cfsPeriodPath, []byte(strconv.Itoa(1000000)), 0644); err != nil {
+		return fmt.Errorf("unable to write CFS period: %v", err)
 	}
-	utils.DoOrDieWithMessage(
-		os.WriteFile(cfsPeriodPath, []byte(strconv.Itoa(1000000)), 0644),
-		"Unable to write CFS period")
 
-	utils.DoOrDieWithMessage(
-		os.WriteFile(cfsQuotaPath, []byte(strconv.Itoa(int(1000000*cpus))), 0644),
-		"Unable to write CFS quota")
+	if err := os.WriteFile(cfsQuotaPath, []byte(strconv.Itoa(int(1000000*cpus))), 0644); err != nil {
+		return fmt.Errorf("unable to write CFS quota: %v", err)
+	}
+	return nil
 }
 
-func setPidsLimit(containerId string, pids int) {
+func setPidsLimit(containerId string, pids int) error {
 	maxProcsPath := "/sys/fs/cgroup/pids/container/" + containerId + "/pids.max"
-	utils.DoOrDieWithMessage(os.WriteFile(maxProcsPath, []byte(strconv.Itoa(pids)), 0644), "Unable to write pids limit")
+	if IsCGroupV2() {
+		maxProcsPath = "/sys/fs/cgroup/container/" + containerId + "/pids.max"
+	}
+	if err := os.WriteFile(maxProcsPath, []byte(strconv.Itoa(pids)), 0644); err != nil {
+		return fmt.Errorf("unable to write pids limit: %v", err)
+	}
+	return nil
+}
+
+// ListCGroups returns the ids of the containers that have a cgroup
+// directory in any controller, whether or not the container still exists.
+func ListCGroups() ([]string, error) {
+	parents := []string{"/sys/fs/cgroup/container"}
+	if !IsCGroupV2() {
+		parents = []string{
+			"/sys/fs/cgroup/memory/container",
+			"/sys/fs/cgroup/cpu/container",
+			"/sys/fs/cgroup/pids/container",
+			"/sys/fs/cgroup/freezer/container",
+			"/sys/fs/cgroup/cpuacct/container",
+			"/sys/fs/cgroup/blkio/container",
+		}
+	}
+
+	seen := map[string]bool{}
+	var containerIds []string
+	for _, parent := range parents {
+		entries, err := os.ReadDir(parent)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				containerIds = append(containerIds, entry.Name())
+			}
+		}
+	}
+	return containerIds, nil
 }
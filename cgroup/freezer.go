@@ -0,0 +1,58 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// FreezeCGroup stops every process in the container's cgroup and waits
+// until the kernel reports the whole group as frozen.
+func FreezeCGroup(containerId string) error {
+	if IsCGroupV2() {
+		return setFreezerState("/sys/fs/cgroup/container/"+containerId, "1")
+	}
+	return setFreezerState("/sys/fs/cgroup/freezer/container/"+containerId, "FROZEN")
+}
+
+// ThawCGroup resumes the processes stopped by FreezeCGroup.
+func ThawCGroup(containerId string) error {
+	if IsCGroupV2() {
+		return setFreezerState("/sys/fs/cgroup/container/"+containerId, "0")
+	}
+	return setFreezerState("/sys/fs/cgroup/freezer/container/"+containerId, "THAWED")
+}
+
+// setFreezerState asks for state and waits until it is reached. On cgroup
+// v1 the freezer controller takes FROZEN or THAWED in freezer.state and
+// reports FREEZING while it is still at work. On v2 the core cgroup.freeze
+// file takes 1 or 0 and the outcome shows up as "frozen 1" or "frozen 0"
+// in cgroup.events.
+func setFreezerState(cgroupDir string, state string) error {
+	controlFile, statusFile, want := cgroupDir+"/freezer.state", cgroupDir+"/freezer.state", state
+	if IsCGroupV2() {
+		controlFile, statusFile, want = cgroupDir+"/cgroup.freeze", cgroupDir+"/cgroup.events", "frozen "+state
+	}
+
+	if err := os.WriteFile(controlFile, []byte(state), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %v", controlFile, err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, err := os.ReadFile(statusFile)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == want {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to report %q", statusFile, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,185 @@
+package cgroup
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats is the resource usage of a container as its cgroups account it.
+// A limit of zero means there is none. HasCPU and HasBlockIO are false if
+// the host does not account CPU time or block I/O, on cgroup v1 without
+// the cpuacct or blkio hierarchy.
+type Stats struct {
+	CPUUsage    time.Duration
+	MemoryUsage uint64
+	MemoryLimit uint64
+	Pids        uint64
+	PidsLimit   uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+	HasCPU      bool
+	HasBlockIO  bool
+}
+
+// GetStats reads the container's current resource usage. Counters a
+// controller does not provide are left at zero.
+func GetStats(containerId string) (*Stats, error) {
+	if IsCGroupV2() {
+		return getStatsV2("/sys/fs/cgroup/container/" + containerId)
+	}
+	return getStatsV1(containerId)
+}
+
+func getStatsV1(containerId string) (*Stats, error) {
+	stats := &Stats{}
+
+	if hasV1Controller("cpuacct") {
+		usage, err := readUint("/sys/fs/cgroup/cpuacct/container/" + containerId + "/cpuacct.usage")
+		if err != nil {
+			return nil, err
+		}
+		stats.CPUUsage = time.Duration(usage)
+		stats.HasCPU = true
+	}
+
+	var err error
+	memoryDir := "/sys/fs/cgroup/memory/container/" + containerId
+	if stats.MemoryUsage, err = readUint(memoryDir + "/memory.usage_in_bytes"); err != nil {
+		return nil, err
+	}
+	// Like docker stats, leave out page cache the kernel can take back
+	// at any time.
+	if memoryStat, err := readKeyedFile(memoryDir + "/memory.stat"); err == nil {
+		if inactive := memoryStat["total_inactive_file"]; inactive < stats.MemoryUsage {
+			stats.MemoryUsage -= inactive
+		}
+	}
+	// An unlimited cgroup reports the largest page aligned int64.
+	if limit, err := readUint(memoryDir + "/memory.limit_in_bytes"); err == nil && limit < 1<<62 {
+		stats.MemoryLimit = limit
+	}
+
+	pidsDir := "/sys/fs/cgroup/pids/container/" + containerId
+	if stats.Pids, err = readUint(pidsDir + "/pids.current"); err != nil {
+		return nil, err
+	}
+	stats.PidsLimit, _ = readUint(pidsDir + "/pids.max")
+
+	// Lines look like "8:0 Read 4096", with a closing "Total" line.
+	ioBytes, err := os.ReadFile("/sys/fs/cgroup/blkio/container/" + containerId + "/blkio.throttle.io_service_bytes")
+	if err == nil {
+		stats.HasBlockIO = true
+		for _, line := range strings.Split(string(ioBytes), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			n, _ := strconv.ParseUint(fields[2], 10, 64)
+			switch fields[1] {
+			case "Read":
+				stats.BlockRead += n
+			case "Write":
+				stats.BlockWrite += n
+			}
+		}
+	}
+	return stats, nil
+}
+
+func getStatsV2(cgroupDir string) (*Stats, error) {
+	stats := &Stats{}
+
+	cpuStat, err := readKeyedFile(cgroupDir + "/cpu.stat")
+	if err != nil {
+		return nil, err
+	}
+	stats.CPUUsage = time.Duration(cpuStat["usage_usec"]) * time.Microsecond
+	stats.HasCPU = true
+
+	if stats.MemoryUsage, err = readUint(cgroupDir + "/memory.current"); err != nil {
+		return nil, err
+	}
+	if memoryStat, err := readKeyedFile(cgroupDir + "/memory.stat"); err == nil {
+		if inactive := memoryStat["inactive_file"]; inactive < stats.MemoryUsage {
+			stats.MemoryUsage -= inactive
+		}
+	}
+	stats.MemoryLimit, _ = readUint(cgroupDir + "/memory.max")
+
+	if stats.Pids, err = readUint(cgroupDir + "/pids.current"); err != nil {
+		return nil, err
+	}
+	stats.PidsLimit, _ = readUint(cgroupDir + "/pids.max")
+
+	// One line per device: "8:0 rbytes=4096 wbytes=0 rios=1 ...".
+	ioStat, err := os.ReadFile(cgroupDir + "/io.stat")
+	if err == nil {
+		stats.HasBlockIO = true
+		for _, line := range strings.Split(string(ioStat), "\n") {
+			for _, field := range strings.Fields(line) {
+				key, value, _ := strings.Cut(field, "=")
+				n, _ := strconv.ParseUint(value, 10, 64)
+				switch key {
+				case "rbytes":
+					stats.BlockRead += n
+				case "wbytes":
+					stats.BlockWrite += n
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+// readUint reads a file holding a single number. "max", which v1 pids
+// and v2 use for no limit, reads as zero.
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readKeyedFile reads a file of "key value" lines such as memory.stat.
+func readKeyedFile(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values, scanner.Err()
+}
+
+// GetOOMKillCount returns how many processes of the container the kernel
+// has killed for running out of memory.
+func GetOOMKillCount(containerId string) (uint64, error) {
+	path := "/sys/fs/cgroup/memory/container/" + containerId + "/memory.oom_control"
+	if IsCGroupV2() {
+		path = "/sys/fs/cgroup/container/" + containerId + "/memory.events"
+	}
+	values, err := readKeyedFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return values["oom_kill"], nil
+}
@@ -6,14 +6,19 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"github.com/sunweiwe/container/common"
 	"github.com/sunweiwe/container/container"
+	"github.com/sunweiwe/container/events"
 	"github.com/sunweiwe/container/exec"
 	"github.com/sunweiwe/container/image"
 	"github.com/sunweiwe/container/network"
+	"github.com/sunweiwe/container/tty"
 	"github.com/sunweiwe/container/utils"
 )
 
@@ -28,7 +33,8 @@ var psCmd = &cobra.Command{
 	Use:   "ps",
 	Short: "ps for all containers",
 	Run: func(cmd *cobra.Command, args []string) {
-		container.PrintRunningContainers()
+		all, _ := cmd.Flags().GetBool("all")
+		container.PrintContainers(all)
 	},
 }
 
@@ -37,23 +43,59 @@ var runCmd = &cobra.Command{
 	Short: "run container",
 	Args:  cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
+		setupContainerBridge()
+		detach, _ := cmd.Flags().GetBool("detach")
 
-		// Create and setup the container0 network bridge we need
-		if isUp, _ := network.IsContainerBridgeUp(); !isUp {
-			log.Println("Bringing up the container bridge...")
-			if err := network.SetupContainerBridge(); err != nil {
-				log.Fatalf("Unable to create container0 bridge: %v", err)
-			}
+		exitCode := container.InitContainer(createOptionsFromFlags(cmd.Flags(), args), detach)
+		os.Exit(exitCode)
+	},
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create",
+	Short: "create a new container without starting it",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		setupContainerBridge()
+
+		containerId, err := container.CreateContainer(createOptionsFromFlags(cmd.Flags(), args))
+		if err != nil {
+			log.Fatalf("Unable to create container: %v", err)
+		}
+		fmt.Println(containerId)
+	},
+}
+
+var startCmd = &cobra.Command{
+	Use:   "start",
+	Short: "start one or more created or stopped containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		attach, _ := cmd.Flags().GetBool("attach")
+		if attach && len(args) > 1 {
+			log.Fatalf("You cannot start and attach multiple containers at once")
 		}
-		memory := cmd.Flags().Int("memory", -1, "Max RAM to allow in MB")
-		swap := cmd.Flags().Int("swap", -1, "Max swap to allow in MB")
-		pids := cmd.Flags().Int("pids", -1, "Number of max processes to allow")
-		cpus := cmd.Flags().Float64("cpus", -1, "Number of CPU cores to restrict to")
-		if err := cmd.Flags().Parse(os.Args[2:]); err != nil {
-			fmt.Println("Error parsing: ", err)
+		for _, ref := range args {
+			containerId := resolveContainerId(ref)
+			exitCode, err := container.StartContainer(containerId, attach)
+			if err != nil {
+				log.Fatalf("Unable to start container: %v", err)
+			}
+			if attach {
+				os.Exit(exitCode)
+			}
+			fmt.Println(ref)
 		}
+	},
+}
 
-		container.InitContainer(cmd.Flags().Args()[0], *memory, *swap, *pids, *cpus, cmd.Flags().Args()[1:])
+var shimCmd = &cobra.Command{
+	Use:    "shim",
+	Short:  "supervise a detached container",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		container.RunShim(args[0])
 	},
 }
 
@@ -76,17 +118,9 @@ var vethCmd = &cobra.Command{
 var childCmd = &cobra.Command{
 	Use:   "childe-mode",
 	Short: "new shell childe mode",
+	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		fs := pflag.FlagSet{}
-		fs.ParseErrorsWhitelist.UnknownFlags = true
-		memory := fs.Int("memory", -1, "Max RAM to allow in MB")
-		swap := fs.Int("swap", -1, "Max swap to allow in MB")
-		pids := fs.Int("pids", -1, "Number of max processes to allow")
-		cpus := fs.Float64("cpus", -1, "Number of CPU cores to restrict to")
-
-		image := cmd.Flags().Lookup("image").Value.String()
-
-		container.ExecContainerCommand(*memory, *swap, *pids, *cpus, args[0], image, args[1:])
+		container.ExecContainerCommand(args[0])
 	},
 }
 
@@ -103,7 +137,119 @@ var execCmd = &cobra.Command{
 	Short: "exec to running container",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		exec.ExecContainer(args[0])
+		if len(args) < 2 {
+			log.Fatalf("Please pass a command to run in the container")
+		}
+		interactive, _ := cmd.Flags().GetBool("interactive")
+		useTty, _ := cmd.Flags().GetBool("tty")
+		os.Exit(exec.ExecContainer(resolveContainerId(args[0]), args[1:], interactive, useTty))
+	},
+}
+
+var commitCmd = &cobra.Command{
+	Use:   "commit",
+	Short: "create a new image from a container's changes",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		changes, _ := cmd.Flags().GetStringArray("change")
+		pause, _ := cmd.Flags().GetBool("pause")
+		imageHash, err := container.CommitContainer(resolveContainerId(args[0]), args[1], changes, pause)
+		if err != nil {
+			log.Fatalf("Unable to commit container: %v", err)
+		}
+		fmt.Println(imageHash)
+	},
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export a container's filesystem as a tar archive",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		out := os.Stdout
+		if output, _ := cmd.Flags().GetString("output"); len(output) > 0 {
+			file, err := os.Create(output)
+			if err != nil {
+				log.Fatalf("Unable to create output file: %v", err)
+			}
+			defer file.Close()
+			out = file
+		} else if tty.IsTerminal(os.Stdout.Fd()) {
+			log.Fatalf("Refusing to write a tar archive to a terminal, use -o or redirect the output")
+		}
+
+		if err := container.ExportContainer(resolveContainerId(args[0]), out); err != nil {
+			log.Fatalf("Unable to export container: %v", err)
+		}
+	},
+}
+
+var cpCmd = &cobra.Command{
+	Use:   "cp",
+	Short: "copy files between a container and the host: cp CONTAINER:SRC DEST | cp SRC CONTAINER:DEST",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		srcContainer, srcPath := splitCpArg(args[0])
+		dstContainer, dstPath := splitCpArg(args[1])
+
+		var err error
+		switch {
+		case len(srcContainer) > 0 && len(dstContainer) > 0:
+			log.Fatalf("Copying between containers is not supported")
+		case len(srcContainer) > 0:
+			if dstPath == "-" && tty.IsTerminal(os.Stdout.Fd()) {
+				log.Fatalf("Refusing to write a tar archive to a terminal, redirect the output")
+			}
+			err = container.CopyFromContainer(resolveContainerId(srcContainer), srcPath, dstPath, os.Stdout)
+		case len(dstContainer) > 0:
+			err = container.CopyToContainer(resolveContainerId(dstContainer), srcPath, dstPath, os.Stdin)
+		default:
+			log.Fatalf("One of the paths must be CONTAINER:PATH")
+		}
+		if err != nil {
+			log.Fatalf("Unable to copy: %v", err)
+		}
+	},
+}
+
+// splitCpArg splits a cp argument into a container and a path in it.
+// Arguments starting with / or . are always host paths.
+func splitCpArg(arg string) (string, string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	if i := strings.Index(arg, ":"); i > 0 {
+		return arg[:i], arg[i+1:]
+	}
+	return "", arg
+}
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "manage images",
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import the contents of a tarball, or - for stdin, as an image",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		changes, _ := cmd.Flags().GetStringArray("change")
+		in := os.Stdin
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("Unable to open archive: %v", err)
+			}
+			defer file.Close()
+			in = file
+		}
+
+		imageHash, err := image.ImportImage(in, args[1], changes)
+		if err != nil {
+			log.Fatalf("Unable to import image: %v", err)
+		}
+		fmt.Println(imageHash)
 	},
 }
 
@@ -116,8 +262,433 @@ var rmiCmd = &cobra.Command{
 	},
 }
 
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "stop one or more running containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		timeout, _ := cmd.Flags().GetInt("time")
+		for _, ref := range args {
+			containerId := resolveContainerId(ref)
+			if err := container.StopContainer(containerId, time.Duration(timeout)*time.Second); err != nil {
+				log.Fatalf("Unable to stop container: %v", err)
+			}
+			fmt.Println(ref)
+		}
+	},
+}
+
+var killCmd = &cobra.Command{
+	Use:   "kill",
+	Short: "send a signal to one or more running containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		signal, _ := cmd.Flags().GetString("signal")
+		sig, err := utils.ParseSignal(signal)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, ref := range args {
+			containerId := resolveContainerId(ref)
+			if err := container.KillContainer(containerId, sig); err != nil {
+				log.Fatalf("Unable to kill container: %v", err)
+			}
+			fmt.Println(ref)
+		}
+	},
+}
+
+var rmCmd = &cobra.Command{
+	Use:   "rm",
+	Short: "remove one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		for _, ref := range args {
+			containerId, err := container.ResolveContainerId(ref)
+			if err != nil {
+				// A container that failed half way through create has no
+				// state to resolve against, but can still be removed by
+				// its full id.
+				if !container.IsContainerId(ref) {
+					log.Fatalf("%v", err)
+				}
+				containerId = ref
+			}
+			if err := container.RemoveContainer(containerId, force); err != nil {
+				log.Fatalf("Unable to remove container: %v", err)
+			}
+			fmt.Println(ref)
+		}
+	},
+}
+
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "block until one or more containers stop, then print their exit codes",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, ref := range args {
+			containerId := resolveContainerId(ref)
+			exitCode, err := container.WaitContainer(containerId)
+			if err != nil {
+				log.Fatalf("Unable to wait for container: %v", err)
+			}
+			fmt.Println(exitCode)
+		}
+	},
+}
+
+var attachCmd = &cobra.Command{
+	Use:   "attach",
+	Short: "attach local standard input, output and error to a running container",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode, err := container.AttachContainer(resolveContainerId(args[0]))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		os.Exit(exitCode)
+	},
+}
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "display the running processes of a container (fields: pid, nspid, user, time, rss, cmd)",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := container.TopContainer(resolveContainerId(args[0]), args[1:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "display a live stream of container resource usage",
+	Run: func(cmd *cobra.Command, args []string) {
+		noStream, _ := cmd.Flags().GetBool("no-stream")
+		format, _ := cmd.Flags().GetString("format")
+		var containerIds []string
+		for _, ref := range args {
+			containerIds = append(containerIds, resolveContainerId(ref))
+		}
+		if err := container.PrintStats(containerIds, noStream, format); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "display detailed information on one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		var containerIds []string
+		for _, ref := range args {
+			containerIds = append(containerIds, resolveContainerId(ref))
+		}
+		if err := container.PrintInspect(containerIds, format); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "get real time events from the runtime",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := events.Options{}
+		for _, name := range []string{"since", "until"} {
+			value, _ := cmd.Flags().GetString(name)
+			if len(value) == 0 {
+				continue
+			}
+			t, err := container.ParseLogTime(value)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if name == "since" {
+				opts.Since = t
+			} else {
+				opts.Until = t
+			}
+		}
+		filters, _ := cmd.Flags().GetStringArray("filter")
+		filter, err := events.ParseFilters(filters)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		opts.Filter = filter
+		opts.Follow, _ = cmd.Flags().GetBool("follow")
+		switch format, _ := cmd.Flags().GetString("format"); format {
+		case "":
+		case "json":
+			opts.JSON = true
+		default:
+			log.Fatalf("Unknown format %q, expected json", format)
+		}
+
+		if err := events.Print(os.Stdout, opts); err != nil {
+			log.Fatalf("Unable to read events: %v", err)
+		}
+	},
+}
+
+var systemCmd = &cobra.Command{
+	Use:   "system",
+	Short: "manage the container runtime on this host",
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "remove mounts, namespaces, links and cgroups left behind by crashed containers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if err := container.PruneSystem(dryRun); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "fetch the logs of a container",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := container.LogsOptions{}
+		opts.Follow, _ = cmd.Flags().GetBool("follow")
+		opts.Timestamps, _ = cmd.Flags().GetBool("timestamps")
+		tail, _ := cmd.Flags().GetString("tail")
+		opts.Tail = -1
+		if tail != "all" {
+			n, err := strconv.Atoi(tail)
+			if err != nil || n < 0 {
+				log.Fatalf("Invalid --tail value: %s", tail)
+			}
+			opts.Tail = n
+		}
+		if since, _ := cmd.Flags().GetString("since"); len(since) > 0 {
+			t, err := container.ParseLogTime(since)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			opts.Since = t
+		}
+
+		if err := container.PrintLogs(resolveContainerId(args[0]), opts); err != nil {
+			log.Fatalf("Unable to read container logs: %v", err)
+		}
+	},
+}
+
+var renameCmd = &cobra.Command{
+	Use:   "rename",
+	Short: "rename a container",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := container.RenameContainer(resolveContainerId(args[0]), args[1]); err != nil {
+			log.Fatalf("Unable to rename container: %v", err)
+		}
+	},
+}
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "update the resource limits of one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		if !flags.Changed("memory") && !flags.Changed("swap") && !flags.Changed("pids") && !flags.Changed("cpus") {
+			log.Fatalf("You must provide one or more flags when using this command")
+		}
+		resources := container.Resources{}
+		resources.Memory, _ = flags.GetInt("memory")
+		resources.Swap, _ = flags.GetInt("swap")
+		resources.Pids, _ = flags.GetInt("pids")
+		resources.Cpus, _ = flags.GetFloat64("cpus")
+
+		for _, ref := range args {
+			if err := container.UpdateContainer(resolveContainerId(ref), resources); err != nil {
+				log.Fatalf("Unable to update container: %v", err)
+			}
+			fmt.Println(ref)
+		}
+	},
+}
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "pause all processes within one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, ref := range args {
+			containerId := resolveContainerId(ref)
+			if err := container.PauseContainer(containerId); err != nil {
+				log.Fatalf("Unable to pause container: %v", err)
+			}
+			fmt.Println(ref)
+		}
+	},
+}
+
+var unpauseCmd = &cobra.Command{
+	Use:   "unpause",
+	Short: "unpause all processes within one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, ref := range args {
+			containerId := resolveContainerId(ref)
+			if err := container.UnpauseContainer(containerId); err != nil {
+				log.Fatalf("Unable to unpause container: %v", err)
+			}
+			fmt.Println(ref)
+		}
+	},
+}
+
+// resolveContainerId accepts a container ID, name or unique ID prefix.
+func resolveContainerId(ref string) string {
+	containerId, err := container.ResolveContainerId(ref)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return containerId
+}
+
+// addCreateFlags registers the flags shared by run and create. Flag parsing
+// stops at the image name so the container command keeps its own flags.
+func addCreateFlags(flags *pflag.FlagSet) {
+	flags.SetInterspersed(false)
+	flags.String("name", "", "Assign a name to the container")
+	flags.Int("memory", -1, "Max RAM to allow in MB")
+	flags.Int("swap", -1, "Max swap to allow in MB")
+	flags.Int("pids", -1, "Number of max processes to allow")
+	flags.Float64("cpus", -1, "Number of CPU cores to restrict to")
+	flags.Bool("rm", false, "Automatically remove the container when it exits")
+	flags.BoolP("interactive", "i", false, "Keep STDIN open even if not attached")
+	flags.BoolP("tty", "t", false, "Allocate a pseudo-TTY")
+	flags.Bool("init", false, "Run an init inside the container that forwards signals and reaps processes")
+	flags.StringArray("log-opt", nil, "Log driver options (max-size, max-file)")
+	flags.String("health-cmd", "", "Command to run to check health")
+	flags.Duration("health-interval", 0, "Time between running the check (default 30s)")
+	flags.Duration("health-timeout", 0, "Maximum time to allow one check to run (default 30s)")
+	flags.Duration("health-start-period", 0, "Start period for the container to initialize before failed checks count")
+	flags.Int("health-retries", 0, "Consecutive failures needed to report unhealthy (default 3)")
+	flags.Bool("no-healthcheck", false, "Disable any container-specified HEALTHCHECK")
+	flags.String("restart", "no", "Restart policy to apply when a container exits (no, on-failure[:max], always, unless-stopped)")
+}
+
+// createOptionsFromFlags turns `<image> <command...>` and the flags from
+// addCreateFlags into the options for a new container.
+func createOptionsFromFlags(flags *pflag.FlagSet, args []string) container.CreateOptions {
+	opts := container.CreateOptions{
+		Image:   args[0],
+		Command: args[1:],
+	}
+	opts.Name, _ = flags.GetString("name")
+	opts.Resources.Memory, _ = flags.GetInt("memory")
+	opts.Resources.Swap, _ = flags.GetInt("swap")
+	opts.Resources.Pids, _ = flags.GetInt("pids")
+	opts.Resources.Cpus, _ = flags.GetFloat64("cpus")
+	opts.AutoRemove, _ = flags.GetBool("rm")
+	opts.OpenStdin, _ = flags.GetBool("interactive")
+	opts.Tty, _ = flags.GetBool("tty")
+	opts.Init, _ = flags.GetBool("init")
+
+	restart, _ := flags.GetString("restart")
+	policy, err := container.ParseRestartPolicy(restart)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if opts.AutoRemove && policy.Name != container.RestartNo {
+		log.Fatalf("Conflicting options: --restart and --rm")
+	}
+	opts.RestartPolicy = policy
+
+	opts.Healthcheck = &common.HealthConfig{}
+	if healthCmd, _ := flags.GetString("health-cmd"); len(healthCmd) > 0 {
+		opts.Healthcheck.Test = []string{"CMD-SHELL", healthCmd}
+	}
+	if noHealthcheck, _ := flags.GetBool("no-healthcheck"); noHealthcheck {
+		opts.Healthcheck.Test = []string{"NONE"}
+	}
+	opts.Healthcheck.Interval, _ = flags.GetDuration("health-interval")
+	opts.Healthcheck.Timeout, _ = flags.GetDuration("health-timeout")
+	opts.Healthcheck.StartPeriod, _ = flags.GetDuration("health-start-period")
+	opts.Healthcheck.Retries, _ = flags.GetInt("health-retries")
+
+	logOpts, _ := flags.GetStringArray("log-opt")
+	if opts.LogConfig, err = container.ParseLogOptions(logOpts); err != nil {
+		log.Fatalf("%v", err)
+	}
+	return opts
+}
+
+// Create and setup the container0 network bridge we need
+func setupContainerBridge() {
+	if isUp, _ := network.IsContainerBridgeUp(); !isUp {
+		log.Println("Bringing up the container bridge...")
+		if err := network.SetupContainerBridge(); err != nil {
+			log.Fatalf("Unable to create container0 bridge: %v", err)
+		}
+	}
+}
+
 func init() {
-	childCmd.PersistentFlags().String("image", "", "Container image")
+	psCmd.Flags().BoolP("all", "a", false, "Show all containers (default shows just running)")
+
+	addCreateFlags(runCmd.Flags())
+	runCmd.Flags().BoolP("detach", "d", false, "Run container in background and print container ID")
+
+	addCreateFlags(createCmd.Flags())
+
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().BoolP("interactive", "i", false, "Keep STDIN open even if not attached")
+	execCmd.Flags().BoolP("tty", "t", false, "Allocate a pseudo-TTY")
+
+	startCmd.Flags().BoolP("attach", "a", false, "Attach to the container and wait for it to exit")
+
+	stopCmd.Flags().IntP("time", "t", 10, "Seconds to wait for stop before killing it")
+
+	killCmd.Flags().StringP("signal", "s", "SIGKILL", "Signal to send to the container")
+
+	rmCmd.Flags().BoolP("force", "f", false, "Force the removal of a running container")
+
+	statsCmd.Flags().Bool("no-stream", false, "Disable streaming stats and only pull the first result")
+	statsCmd.Flags().String("format", "", "Output format: table (default) or json")
+
+	commitCmd.Flags().StringArrayP("change", "c", nil, "Apply a CMD or ENV instruction to the created image")
+	commitCmd.Flags().BoolP("pause", "p", true, "Pause the container during commit")
+
+	exportCmd.Flags().StringP("output", "o", "", "Write to a file, instead of STDOUT")
+
+	importCmd.Flags().StringArrayP("change", "c", nil, "Apply a CMD or ENV instruction to the created image")
+	imageCmd.AddCommand(importCmd)
+
+	updateCmd.Flags().Int("memory", -1, "Max RAM to allow in MB")
+	updateCmd.Flags().Int("swap", -1, "Max swap to allow in MB")
+	updateCmd.Flags().Int("pids", -1, "Number of max processes to allow")
+	updateCmd.Flags().Float64("cpus", -1, "Number of CPU cores to restrict to")
+
+	inspectCmd.Flags().StringP("format", "f", "", "Format the output using the given Go template")
+
+	eventsCmd.Flags().String("since", "", "Show events since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)")
+	eventsCmd.Flags().String("until", "", "Stream events until this timestamp")
+	eventsCmd.Flags().BoolP("follow", "f", false, "Keep streaming new events after replaying --since/--until")
+	eventsCmd.Flags().StringArray("filter", nil, "Filter output based on conditions provided (e.g. type=container)")
+	eventsCmd.Flags().String("format", "", "Output format: text (default) or json")
+
+	pruneCmd.Flags().Bool("dry-run", false, "Only list what would be removed")
+	systemCmd.AddCommand(pruneCmd)
+
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	logsCmd.Flags().String("tail", "all", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().String("since", "", "Show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)")
+	logsCmd.Flags().BoolP("timestamps", "t", false, "Show timestamps")
 }
 
 func Execute() {
@@ -133,7 +704,7 @@ func Execute() {
 		log.Fatalf("Unable to create requisite directories: %v", err)
 	}
 
-	rootCmd.AddCommand(runCmd, netnsCmd, vethCmd, childCmd, psCmd, imagesCmd, execCmd, rmiCmd)
+	rootCmd.AddCommand(runCmd, createCmd, startCmd, shimCmd, netnsCmd, vethCmd, childCmd, psCmd, imagesCmd, execCmd, rmiCmd, stopCmd, killCmd, rmCmd, pauseCmd, unpauseCmd, waitCmd, renameCmd, logsCmd, attachCmd, systemCmd, topCmd, statsCmd, inspectCmd, eventsCmd, updateCmd, commitCmd, exportCmd, imageCmd, cpCmd)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -1,6 +1,8 @@
 //Package common types
 package common
 
+import "time"
+
 type Manifest []struct {
 	Config   string
 	RepoTags []string
@@ -8,8 +10,21 @@ type Manifest []struct {
 }
 
 type ImageConfigDetails struct {
-	Env []string `json:"Env"`
-	Cmd []string `json:"Cmd"`
+	Env         []string      `json:"Env"`
+	Cmd         []string      `json:"Cmd"`
+	StopSignal  string        `json:"StopSignal"`
+	Healthcheck *HealthConfig `json:"Healthcheck"`
+}
+
+// HealthConfig is the HEALTHCHECK of an image. Test is ["NONE"],
+// ["CMD", args...] or ["CMD-SHELL", command]; the durations are in
+// nanoseconds, zero meaning the default.
+type HealthConfig struct {
+	Test        []string      `json:"Test,omitempty"`
+	Interval    time.Duration `json:"Interval,omitempty"`
+	Timeout     time.Duration `json:"Timeout,omitempty"`
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"`
+	Retries     int           `json:"Retries,omitempty"`
 }
 
 type ImageConfig struct {
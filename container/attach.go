@@ -0,0 +1,301 @@
+package container
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sunweiwe/container/tty"
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Every supervisor listens on attach.sock in the container directory.
+	Clients and supervisor talk in frames: an 8 byte header holding the
+	frame kind and, in its last four bytes, the big-endian length of the
+	payload that follows. The supervisor sends stdout and stderr frames,
+	clients send stdin and resize frames.
+*/
+
+const (
+	frameStdin byte = iota
+	frameStdout
+	frameStderr
+	frameResize
+)
+
+const frameHeaderSize = 8
+
+// Larger frames are refused rather than allocated; the length comes from
+// the other end of the socket.
+const maxFramePayload = 1 << 20
+
+// Output is never held up for longer than this by a client that does not
+// read it; the client is dropped instead.
+const attachWriteTimeout = 5 * time.Second
+
+// The detach sequence, ctrl-p ctrl-q.
+const (
+	ctrlP = 0x10
+	ctrlQ = 0x11
+)
+
+func attachSocketPath(containerId string) string {
+	return containerHome(containerId) + "/attach.sock"
+}
+
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[4:frameHeaderSize], uint32(len(payload)))
+	copy(frame[frameHeaderSize:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[4:])
+	if size > maxFramePayload {
+		return 0, nil, fmt.Errorf("attach frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// attachServer hands the container's output to every attached client
+// and passes their input and terminal size on to the container.
+type attachServer struct {
+	listener    net.Listener
+	stdio       *containerIO
+	acceptInput bool
+
+	mu      sync.Mutex
+	clients map[net.Conn]bool
+}
+
+func listenAttach(containerId string, stdio *containerIO, acceptInput bool) (*attachServer, error) {
+	path := attachSocketPath(containerId)
+	// Left behind by a supervisor that did not get to clean up.
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	server := &attachServer{
+		listener:    listener,
+		stdio:       stdio,
+		acceptInput: acceptInput,
+		clients:     map[net.Conn]bool{},
+	}
+	go server.serve()
+	return server, nil
+}
+
+func (s *attachServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.clients[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *attachServer) handle(conn net.Conn) {
+	defer s.drop(conn)
+	for {
+		kind, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case frameStdin:
+			if s.acceptInput {
+				s.stdio.writeInput(payload)
+			}
+		case frameResize:
+			if len(payload) == 4 {
+				s.stdio.Resize(&unix.Winsize{
+					Row: binary.BigEndian.Uint16(payload[0:2]),
+					Col: binary.BigEndian.Uint16(payload[2:4]),
+				})
+			}
+		}
+	}
+}
+
+func (s *attachServer) drop(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.clients, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *attachServer) broadcast(kind byte, p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if err := writeFrame(conn, kind, p); err != nil {
+			delete(s.clients, conn)
+			conn.Close()
+		}
+	}
+}
+
+// stream returns a writer that sends everything written to it to the
+// attached clients as frames of the given kind.
+func (s *attachServer) stream(kind byte) io.Writer {
+	return attachStreamWriter{server: s, kind: kind}
+}
+
+type attachStreamWriter struct {
+	server *attachServer
+	kind   byte
+}
+
+// Write never fails, a client going away is no concern of the container.
+func (w attachStreamWriter) Write(p []byte) (int, error) {
+	for rest := p; len(rest) > 0; {
+		n := len(rest)
+		if n > maxFramePayload {
+			n = maxFramePayload
+		}
+		w.server.broadcast(w.kind, rest[:n])
+		rest = rest[n:]
+	}
+	return len(p), nil
+}
+
+// close disconnects every client, which tells them the container is done.
+func (s *attachServer) close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.clients = map[net.Conn]bool{}
+	s.mu.Unlock()
+}
+
+// AttachContainer connects our stdin, stdout and stderr, or our terminal
+// if the container has one, to a running container. It returns the
+// container's exit status once it is done, or as soon as the user
+// detaches with ctrl-p ctrl-q, in which case the container keeps running.
+func AttachContainer(containerId string) (int, error) {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return 0, fmt.Errorf("no such container: %s", containerId)
+	}
+	if !state.IsRunning() && state.Status != StatusRestarting {
+		return 0, fmt.Errorf("container %s is not running", containerId)
+	}
+
+	conn, err := net.Dial("unix", attachSocketPath(containerId))
+	if err != nil {
+		return 0, fmt.Errorf("unable to attach to container %s: %v", containerId, err)
+	}
+	defer conn.Close()
+
+	// Frames from the stdin and resize goroutines must not interleave.
+	var writeMu sync.Mutex
+	send := func(kind byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeFrame(conn, kind, payload)
+	}
+
+	if state.Tty && tty.IsTerminal(os.Stdin.Fd()) {
+		restoreTerminal, err := tty.Relay(os.Stdin.Fd(), func(ws *unix.Winsize) {
+			payload := make([]byte, 4)
+			binary.BigEndian.PutUint16(payload[0:2], ws.Row)
+			binary.BigEndian.PutUint16(payload[2:4], ws.Col)
+			send(frameResize, payload)
+		})
+		if err != nil {
+			return 0, err
+		}
+		defer restoreTerminal()
+	}
+
+	detached := make(chan struct{})
+	go func() {
+		if detachKeysPressed(os.Stdin, func(p []byte) error { return send(frameStdin, p) }) {
+			close(detached)
+			conn.Close()
+		}
+	}()
+
+	for {
+		kind, payload, err := readFrame(conn)
+		if err != nil {
+			break
+		}
+		switch kind {
+		case frameStdout:
+			os.Stdout.Write(payload)
+		case frameStderr:
+			os.Stderr.Write(payload)
+		}
+	}
+
+	select {
+	case <-detached:
+		return 0, nil
+	default:
+	}
+	// The supervisor hangs up once the container has exited for good.
+	return waitContainer(containerId, true)
+}
+
+// detachKeysPressed copies input to send until the detach sequence shows
+// up in it, and reports whether it did. A ctrl-p that turns out not to
+// start the sequence is passed on with the key that follows it.
+func detachKeysPressed(input io.Reader, send func([]byte) error) bool {
+	buf := make([]byte, 32*1024)
+	pending := false
+	for {
+		n, err := input.Read(buf)
+		out := make([]byte, 0, n+1)
+		for _, b := range buf[:n] {
+			if pending {
+				pending = false
+				if b == ctrlQ {
+					return true
+				}
+				out = append(out, ctrlP)
+			}
+			if b == ctrlP {
+				pending = true
+				continue
+			}
+			out = append(out, b)
+		}
+		if len(out) > 0 {
+			if send(out) != nil {
+				return false
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Unable to read input: %v\n", err)
+			}
+			return false
+		}
+	}
+}
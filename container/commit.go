@@ -0,0 +1,54 @@
+package container
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sunweiwe/container/image"
+	"github.com/sunweiwe/container/tar"
+	"github.com/sunweiwe/container/utils"
+)
+
+// CommitContainer saves the changes made to a container's file system as
+// a new image on top of the one it was created from, with changes applied
+// to the image config. A running container is paused while its upper
+// directory is read, unless pause is false. It returns the image's hash.
+func CommitContainer(containerId string, ref string, changes []string, pause bool) (string, error) {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return "", fmt.Errorf("no such container: %s", containerId)
+	}
+	imageName, tag := image.GetImageNameAndTag(ref)
+	upperDir := containerHome(containerId) + "/fs/upperdir"
+
+	if pause && state.Status == StatusRunning {
+		if err := PauseContainer(containerId); err != nil {
+			return "", err
+		}
+		defer UnpauseContainer(containerId)
+	}
+
+	layerFile, err := image.NewLayerFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(layerFile.Name())
+	defer layerFile.Close()
+	err = tar.Tar(layerFile, upperDir, true)
+	if err == nil {
+		err = layerFile.Sync()
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to archive container changes: %v", err)
+	}
+
+	// The upper directory already is an overlay layer, whiteouts and all.
+	imageHash, err := image.CreateImage(imageName, tag, state.ImageHash, layerFile.Name(),
+		func(dir string) error { return utils.CopyTree(upperDir, dir, false) },
+		changes, "container commit "+containerId)
+	if err != nil {
+		return "", err
+	}
+	emitEvent(state, "commit", map[string]string{"imageId": imageHash, "imageName": imageName + ":" + tag})
+	return imageHash, nil
+}
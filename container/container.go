@@ -1,26 +1,19 @@
 package container
 
 import (
-	"bufio"
 	"crypto/rand"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/sunweiwe/container/events"
 	"github.com/sunweiwe/container/image"
 	"github.com/sunweiwe/container/utils"
 )
 
-type RunningContainerInfo struct {
-	ContainerId string
-	Image       string
-	Command     string
-	Pid         int
-}
-
 // CreateContainerId
 func CreateContainerId() string {
 	randBytes := make([]byte, 6)
@@ -30,144 +23,64 @@ func CreateContainerId() string {
 		randBytes[3], randBytes[4], randBytes[5])
 }
 
-func getDistribution(containerId string) (string, error) {
-	var lines []string
-	file, err := os.Open("/proc/mounts")
-	if err != nil {
-		fmt.Println("Unable to read /proc/mounts")
-		return "", err
+// IsContainerId reports whether s has the form of the ids made by
+// CreateContainerId, twelve lowercase hex digits.
+func IsContainerId(s string) bool {
+	if len(s) != 12 {
+		return false
 	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
-
-	for _, line := range lines {
-		if strings.Contains(line, containerId) {
-			parts := strings.Split(line, " ")
-			for _, part := range parts {
-				if strings.Contains(part, "lowerdir=") {
-					options := strings.Split(part, ",")
-					for _, option := range options {
-						if strings.Contains(option, "lowerdir=") {
-							imagesPath := "/var/lib/container/images"
-							leaderString := "lowerdir=" + imagesPath + "/"
-							trailerString := option[len(leaderString):]
-							imageId := trailerString[:12]
-							image, tag := image.GetImageAndTagForHash(imageId)
-							return fmt.Sprintf("%s:%s", image, tag), nil
-						}
-					}
-				}
-			}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
 		}
 	}
-	return "", nil
+	return true
 }
 
-func GetRunningContainerInfoForId(containerId string) (RunningContainerInfo, error) {
-	container := RunningContainerInfo{}
-	var procs []string
-	basePath := "/sys/fs/cgroup/cpu/container/"
-
-	file, err := os.Open(basePath + containerId + "/cgroup.procs")
+// GetRunningContainers returns the containers whose process is alive,
+// paused ones included, as recorded in the state store.
+func GetRunningContainers() ([]*State, error) {
+	var containers []*State
+	states, err := ListStates()
 	if err != nil {
-		fmt.Println("Unable to read cgroup.procs")
-		return container, err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		procs = append(procs, scanner.Text())
+		return nil, err
 	}
-
-	if len(procs) > 0 {
-		pid, err := strconv.Atoi(procs[len(procs)-1])
-		if err != nil {
-			fmt.Println("Unable to read PID")
-			return container, err
-		}
-		cmd, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
-		log.Printf("cmd len is: %d\n", len(cmd))
-
-		if err != nil {
-			fmt.Println("Unable to resolve path")
-			return container, err
-		}
-		containerMountPath := "/var/run/container/containers/" + containerId + "/fs/mnt"
-		realContainerMountPath, err := filepath.EvalSymlinks(containerMountPath)
-		if err != nil {
-			fmt.Println("Unable to read command link.")
-			return container, err
-		}
-
-		image, _ := getDistribution(containerId)
-		container = RunningContainerInfo{
-			ContainerId: containerId,
-			Image:       image,
-			Command:     cmd[len(realContainerMountPath):],
-			Pid:         pid,
+	for _, s := range states {
+		if s.IsRunning() {
+			containers = append(containers, s)
 		}
 	}
-
-	return container, nil
+	return containers, nil
 }
 
-/*
-	获取所有正在运行的 container ids
-	实现逻辑:
-		- 容器会创建很多文件夹，在 /sys/fs/cgroup 目录下
-		- 例如, for setting cpu limits, container uses /sys/fs/cgroup/cpu/container
-	- 在那个文件夹里面是文件夹，每个文件夹都是当前运行的容器
-	- 这些文件夹名就是我们创建的容器id。
-	- getContainerInfoForId() does more work. It gathers more information about running
-		containers. See struct RunningContainerInfo for details.
-	- Inside each of those folders is a "cgroup.procs" file that has the list
-		of PIDs of processes inside of that container. From the PID, we can
-		get the mounted path from which the process was started. From that
-		mounted path, we can get the image of the containers since containers
-		are mounted via the overlay file system.
-*/
-func GetRunningContainers() ([]RunningContainerInfo, error) {
-	var containers []RunningContainerInfo
-	basePath := "/sys/fs/cgroup/cpu/container"
-
-	entries, err := os.ReadDir(basePath)
-	if os.IsNotExist(err) {
-		log.Printf("Cgroup cpu container is not exist\n")
-		return containers, nil
-	}
+func PrintContainers(all bool) {
+	containers, err := ListStates()
 	if err != nil {
-		return nil, err
+		log.Fatalf("Unable to list containers: %v\n", err)
 	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			log.Printf("Cgroup cpu container entry is: %s\n", entry.Name())
-			container, _ := GetRunningContainerInfoForId(entry.Name())
-			if container.Pid > 0 {
-				log.Printf("Cgroup cpu container entry is: %s\n", entry.Name())
-				containers = append(containers, container)
-			}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tNAMES")
+	for _, c := range containers {
+		if !all && !c.IsRunning() {
+			continue
 		}
+		fmt.Fprintf(w, "%s\t%s\t%q\t%s ago\t%s\t%s\n", c.Id, c.Image, strings.Join(c.Command, " "),
+			humanDuration(time.Since(c.Created)), c.StatusString(), c.Name)
 	}
-	return containers, err
+	w.Flush()
 }
 
-func PrintRunningContainers() {
-	containers, err := GetRunningContainers()
-	if err != nil {
-		os.Exit(1)
+// emitEvent records a container lifecycle event in the journal, tagged
+// with the container's name and image like every container event.
+func emitEvent(state *State, action string, attributes map[string]string) {
+	attrs := map[string]string{"image": state.Image}
+	if len(state.Name) > 0 {
+		attrs["name"] = state.Name
 	}
-	fmt.Println("CONTAINER ID\tIMAGE\t\tCOMMAND")
-	for _, container := range containers {
-		fmt.Printf("%s\t%s\t%s\n", container.ContainerId, container.Image, container.Command)
+	for key, value := range attributes {
+		attrs[key] = value
 	}
+	events.Emit(events.TypeContainer, action, state.Id, attrs)
 }
 
 func GetPidForRunningContainer(containerId string) int {
@@ -177,7 +90,7 @@ func GetPidForRunningContainer(containerId string) int {
 	}
 
 	for _, c := range containers {
-		if c.ContainerId == containerId {
+		if c.Id == containerId {
 			return c.Pid
 		}
 	}
@@ -190,14 +103,14 @@ func RemoveImageByHash(imageHash string) {
 		log.Fatalf("No such image")
 	}
 
-	containers, err := GetRunningContainers()
+	containers, err := ListStates()
 	if err != nil {
-		log.Fatalf("Unable to get running containers list: %v\n", err)
+		log.Fatalf("Unable to get containers list: %v\n", err)
 	}
 	for _, container := range containers {
-		if container.Image == imageName+":"+imageTag {
-			log.Fatalf("Cannot delete image because it is in use by: %s",
-				container.ContainerId)
+		if container.ImageHash == imageHash {
+			log.Fatalf("Cannot delete image %s:%s because it is in use by: %s",
+				imageName, imageTag, container.Id)
 		}
 	}
 
@@ -205,4 +118,5 @@ func RemoveImageByHash(imageHash string) {
 		"Unable to remove image directory")
 
 	image.RemoveImageMetadata(imageHash)
+	events.Emit(events.TypeImage, "rmi", imageHash, map[string]string{"name": imageName + ":" + imageTag})
 }
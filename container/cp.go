@@ -0,0 +1,102 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sunweiwe/container/tar"
+	"github.com/sunweiwe/container/utils"
+)
+
+// CopyFromContainer copies the file or directory at srcPath in the
+// container to dst on the host, keeping ownership and modes. If dst is an
+// existing directory the copy is put in it. If dst is "-" the copy is
+// written to out as a tar archive instead.
+func CopyFromContainer(containerId string, srcPath string, dst string, out io.Writer) error {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", containerId)
+	}
+
+	// An exited container has no merged view, so it is mounted for the copy
+	// from its upper and lower directories.
+	return withContainerFs(state, func(root string) error {
+		src, err := utils.ResolveInRoot(root, srcPath, false)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(src); err != nil {
+			return fmt.Errorf("no such file or directory in container: %s", srcPath)
+		}
+		name := filepath.Base(filepath.Clean("/" + srcPath))
+
+		if dst == "-" {
+			return tar.TarPath(out, src, name)
+		}
+		if info, err := os.Stat(dst); err == nil && info.IsDir() {
+			return copyPath(src, name, dst, "")
+		}
+		if info, err := os.Stat(filepath.Dir(dst)); err != nil || !info.IsDir() {
+			return fmt.Errorf("destination directory %s does not exist", filepath.Dir(dst))
+		}
+		return copyPath(src, filepath.Base(dst), filepath.Dir(dst), "")
+	})
+}
+
+// CopyToContainer copies the file or directory src on the host to dstPath
+// in the container, keeping ownership and modes. If dstPath is an existing
+// directory the copy is put in it. If src is "-" a tar archive read from
+// in is extracted into dstPath, which must be a directory.
+func CopyToContainer(containerId string, src string, dstPath string, in io.Reader) error {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", containerId)
+	}
+
+	return withContainerFs(state, func(root string) error {
+		// Resolved inside the container, symlinks on the way to the
+		// destination cannot lead out of it.
+		dst, err := utils.ResolveInRoot(root, dstPath, true)
+		if err != nil {
+			return err
+		}
+		dir, err := filepath.Rel(root, dst)
+		if err != nil {
+			return err
+		}
+		info, statErr := os.Stat(dst)
+		isDir := statErr == nil && info.IsDir()
+
+		if src == "-" {
+			if !isDir {
+				return fmt.Errorf("destination %s must be a directory", dstPath)
+			}
+			return tar.Extract(in, root, dir)
+		}
+		if _, err := os.Lstat(src); err != nil {
+			return err
+		}
+		if isDir {
+			return copyPath(src, filepath.Base(src), root, dir)
+		}
+		if info, err := os.Stat(filepath.Dir(dst)); err != nil || !info.IsDir() {
+			return fmt.Errorf("destination directory %s does not exist in container", filepath.Dir(dstPath))
+		}
+		return copyPath(src, filepath.Base(dst), root, filepath.Dir(dir))
+	})
+}
+
+// copyPath copies the tree at src to name in dir of the tree under root by
+// streaming it through a tar archive.
+func copyPath(src string, name string, root string, dir string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(tar.TarPath(writer, src, name))
+	}()
+	err := tar.Extract(reader, root, dir)
+	// Unblocks the writer if extracting stopped halfway.
+	reader.Close()
+	return err
+}
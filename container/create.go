@@ -0,0 +1,241 @@
+package container
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sunweiwe/container/cgroup"
+	"github.com/sunweiwe/container/common"
+	"github.com/sunweiwe/container/events"
+	"github.com/sunweiwe/container/image"
+	"github.com/sunweiwe/container/network"
+	"github.com/sunweiwe/container/utils"
+	"golang.org/x/sys/unix"
+)
+
+// CreateOptions describe the container to create, as given to run or
+// create on the command line.
+type CreateOptions struct {
+	Name          string
+	Image         string
+	Command       []string
+	Resources     Resources
+	AutoRemove    bool
+	Tty           bool
+	OpenStdin     bool
+	Init          bool
+	RestartPolicy RestartPolicy
+	LogConfig     LogConfig
+	Healthcheck   *common.HealthConfig
+}
+
+// 创建容器，但不启动容器进程
+// 1. 创建容器 id
+// 2. 下载 image
+// 3. 创建容器工作目录, 记录容器状态
+// 4. 挂载文件 overlay 方式
+// 5. 创建 veth pair
+// 6. 创建 netns
+// 7. 挂载 veth
+// 8. 创建并配置 cgroups
+// 任何一步失败都会按相反顺序撤销之前的步骤
+func CreateContainer(opts CreateOptions) (string, error) {
+	containerId := CreateContainerId()
+	log.Printf("New container ID: %s\n", containerId)
+
+	// imageHash
+	imageHash := image.DownloadImageIfRequired(opts.Image)
+	log.Printf("Image to overlay mount: %s\n", imageHash)
+	// Read before anything is created, so a broken image leaves nothing
+	// behind to roll back.
+	imageConfig, err := image.ReadContainerConfig(imageHash)
+	if err != nil {
+		return "", err
+	}
+
+	var undo undoStack
+	fail := func(err error) (string, error) {
+		undo.unwind()
+		return "", err
+	}
+
+	// create container directories
+	undo.push("create container directories", func() error {
+		return os.RemoveAll(containerHome(containerId))
+	})
+	if err := createContainerDirectories(containerId); err != nil {
+		return fail(err)
+	}
+
+	// 记录容器状态
+	imgName, imgTag := image.GetImageNameAndTag(opts.Image)
+	state := &State{
+		Id:         containerId,
+		Name:       opts.Name,
+		Image:      imgName + ":" + imgTag,
+		ImageHash:  imageHash,
+		Command:    opts.Command,
+		Resources:  opts.Resources,
+		AutoRemove: opts.AutoRemove,
+		Tty:        opts.Tty,
+		OpenStdin:  opts.OpenStdin,
+		Init:       opts.Init,
+		Status:     StatusCreated,
+		Created:    time.Now(),
+
+		RestartPolicy: opts.RestartPolicy,
+		LogConfig:     opts.LogConfig,
+		Healthcheck:   mergeHealthConfig(imageConfig.Config.Healthcheck, opts.Healthcheck),
+	}
+	if err := withNamesLock(func() error {
+		if len(state.Name) > 0 {
+			if err := checkNameAvailable(state.Name); err != nil {
+				return err
+			}
+		}
+		return state.Save()
+	}); err != nil {
+		return fail(err)
+	}
+
+	if err := setupContainer(state, &undo); err != nil {
+		return fail(err)
+	}
+	emitEvent(state, "create", nil)
+	return containerId, nil
+}
+
+// setupContainer builds everything the container process needs before it
+// can be started: the overlay root filesystem, the network namespace with
+// its veth pair and the cgroups with their limits. It is run on create
+// and again when an exited container is started.
+//
+// The undo action of each step is pushed before the step is taken, since
+// a step can fail half way and every undo action copes with finding
+// nothing to undo.
+func setupContainer(state *State, undo *undoStack) error {
+	// 挂载容器文件系统 overlay
+	undo.push("mount container filesystem", func() error { return unmountContainerFs(state.Id) })
+	if err := mountOverlayFileSystem(state.Id, state.ImageHash); err != nil {
+		return err
+	}
+
+	// 设置网络 eth
+	undo.push("set up veth on host", func() error { return network.RemoveVirtualEthOnHost(state.Id) })
+	if err := network.SetUpVirtualEthOnHost(state.Id); err != nil {
+		return fmt.Errorf("unable to setup eth0 on host: %v", err)
+	}
+	undo.push("set up network namespace", func() error { return unmountNetworkNamespace(state.Id) })
+	if err := setupNetwork(state.Id); err != nil {
+		return err
+	}
+	events.Emit(events.TypeNetwork, "connect", "container0", map[string]string{"container": state.Id})
+
+	undo.push("set up cgroups", func() error { return cgroup.RemoveCGroups(state.Id) })
+	if err := cgroup.SetupCGroups(state.Id); err != nil {
+		return err
+	}
+	return cgroup.ConfigureCGroups(state.Id, state.Resources.Memory, state.Resources.Swap,
+		state.Resources.Pids, state.Resources.Cpus)
+}
+
+// undoStack remembers how to undo the setup steps taken so far.
+type undoStack struct {
+	steps []undoStep
+}
+
+type undoStep struct {
+	name string
+	undo func() error
+}
+
+func (u *undoStack) push(name string, undo func() error) {
+	u.steps = append(u.steps, undoStep{name: name, undo: undo})
+}
+
+// unwind undoes the steps in reverse order. It carries on past a step that
+// cannot be undone so that as little as possible is left behind.
+func (u *undoStack) unwind() {
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		if err := u.steps[i].undo(); err != nil {
+			log.Printf("Unable to undo %s: %v\n", u.steps[i].name, err)
+		}
+	}
+	u.steps = nil
+}
+
+func createContainerDirectories(containerId string) error {
+	containerHome := "/var/run/container/containers/" + containerId + "/fs"
+	containerDirs := []string{containerHome, containerHome + "/mnt", containerHome + "/upperdir", containerHome + "/workdir"}
+	if err := utils.CreateDirsIfNotExist(containerDirs); err != nil {
+		return fmt.Errorf("unable to create required directories: %v", err)
+	}
+	return nil
+}
+
+// overlayLowerDirs returns the image layers that make up the container's
+// root filesystem, topmost first, as overlayfs wants them.
+func overlayLowerDirs(imageHash string) ([]string, error) {
+	var srcLayers []string
+	pathManifest := "/var/lib/container/images/" + imageHash + "/" + imageHash + ".json"
+	mani := common.Manifest{}
+	utils.ParseManifest(pathManifest, &mani)
+	if len(mani) == 0 || len(mani[0].Layers) == 0 {
+		return nil, fmt.Errorf("could not find any layer")
+	}
+	if len(mani) > 1 {
+		return nil, fmt.Errorf("more than one manifest is not supported")
+	}
+
+	imageBasePath := "/var/lib/container/images/" + imageHash
+	for _, layer := range mani[0].Layers {
+		srcLayers = append([]string{imageBasePath + "/" + layer[:12] + "/fs"}, srcLayers...)
+	}
+	return srcLayers, nil
+}
+
+func mountOverlayFileSystem(containerId string, imageHash string) error {
+	srcLayers, err := overlayLowerDirs(imageHash)
+	if err != nil {
+		return err
+	}
+
+	containerFsHome := "/var/run/container/containers/" + containerId + "/fs"
+	mntOptions := "lowerdir=" + strings.Join(srcLayers, ":") + ",upperdir=" + containerFsHome + "/upperdir,workdir=" + containerFsHome + "/workdir"
+	if err := unix.Mount("none", containerFsHome+"/mnt", "overlay", 0, mntOptions); err != nil {
+		return fmt.Errorf("mount failed: %v", err)
+	}
+	return nil
+}
+
+// setupNetwork creates the container's network namespace and moves the
+// container end of the veth pair into it. Both steps unshare or setns, so
+// they run in a fresh copy of ourselves rather than in this process.
+func setupNetwork(containerId string) error {
+	// setup the network namespace
+	cmd := &exec.Cmd{
+		Path:   "/proc/self/exe",
+		Args:   []string{"/proc/self/exe", "setup-netns", containerId},
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to setup network namespace: %v", err)
+	}
+
+	// Namespace and setup the virtual interface
+	cmd = &exec.Cmd{
+		Path:   "/proc/self/exe",
+		Args:   []string{"/proc/self/exe", "setup-veth", containerId},
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to setup container network interface: %v", err)
+	}
+	return nil
+}
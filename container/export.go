@@ -0,0 +1,79 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sunweiwe/container/tar"
+)
+
+// ExportContainer writes the container's file system, the image with the
+// container's changes on top, to out as a tar archive.
+func ExportContainer(containerId string, out io.Writer) error {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return fmt.Errorf("no such container: %s", containerId)
+	}
+	err = withContainerFs(state, func(root string) error {
+		return tar.Tar(out, root, false)
+	})
+	if err != nil {
+		return err
+	}
+	emitEvent(state, "export", nil)
+	return nil
+}
+
+// withContainerFs calls fn with the root of the container's merged file
+// system. The overlay is mounted from create until the container exits;
+// for an exited container it is mounted for the duration of the call.
+func withContainerFs(state *State, fn func(root string) error) error {
+	root := containerHome(state.Id) + "/fs/mnt"
+	mounted, err := isMountPoint(root)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		if err := mountOverlayFileSystem(state.Id, state.ImageHash); err != nil {
+			return err
+		}
+		defer unmountContainerFs(state.Id)
+	}
+	return fn(root)
+}
+
+func isMountPoint(path string) (bool, error) {
+	mountPoints, err := listMountPoints()
+	if err != nil {
+		return false, err
+	}
+	for _, mountPoint := range mountPoints {
+		if mountPoint == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listMountPoints returns the mount points in our mount namespace.
+func listMountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mountPoints []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The mount point is the fifth field, see proc(5).
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 5 {
+			mountPoints = append(mountPoints, fields[4])
+		}
+	}
+	return mountPoints, scanner.Err()
+}
@@ -0,0 +1,237 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+
+	"github.com/sunweiwe/container/common"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 30 * time.Second
+	defaultHealthRetries  = 3
+
+	// Only this many of the latest results are kept, and only this much
+	// of each result's output.
+	maxHealthLog    = 5
+	maxHealthOutput = 4096
+)
+
+// Health is the outcome of a container's health checks during its
+// current run.
+type Health struct {
+	Status        string         `json:"status"`
+	FailingStreak int            `json:"failingStreak"`
+	Log           []HealthResult `json:"log"`
+}
+
+// HealthResult is one run of the health check command.
+type HealthResult struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exitCode"`
+	Output   string    `json:"output"`
+}
+
+// mergeHealthConfig lays the health options given on the command line
+// over the image's HEALTHCHECK. It returns nil if there is no check to
+// run.
+func mergeHealthConfig(image *common.HealthConfig, opts *common.HealthConfig) *common.HealthConfig {
+	config := common.HealthConfig{}
+	if image != nil {
+		config = *image
+	}
+	if opts != nil {
+		if len(opts.Test) > 0 {
+			config.Test = opts.Test
+		}
+		if opts.Interval > 0 {
+			config.Interval = opts.Interval
+		}
+		if opts.Timeout > 0 {
+			config.Timeout = opts.Timeout
+		}
+		if opts.StartPeriod > 0 {
+			config.StartPeriod = opts.StartPeriod
+		}
+		if opts.Retries > 0 {
+			config.Retries = opts.Retries
+		}
+	}
+	if len(config.Test) == 0 || config.Test[0] == "NONE" {
+		return nil
+	}
+	if config.Interval == 0 {
+		config.Interval = defaultHealthInterval
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultHealthTimeout
+	}
+	if config.Retries == 0 {
+		config.Retries = defaultHealthRetries
+	}
+	return &config
+}
+
+// healthCommand turns the Test of a health check into the command to run
+// in the container.
+func healthCommand(test []string) ([]string, error) {
+	switch {
+	case len(test) > 1 && test[0] == "CMD":
+		return test[1:], nil
+	case len(test) == 2 && test[0] == "CMD-SHELL":
+		return []string{"/bin/sh", "-c", test[1]}, nil
+	}
+	return nil, fmt.Errorf("invalid health check: %q", test)
+}
+
+// startHealthMonitor runs the container's health check every interval
+// for as long as the current run of the container lasts. The returned
+// function stops it, cancelling a check that is under way.
+func startHealthMonitor(containerId string, config *common.HealthConfig) func() {
+	if config == nil {
+		return func() {}
+	}
+	command, err := healthCommand(config.Test)
+	if err != nil {
+		log.Printf("Not checking the health of container %s: %v\n", containerId, err)
+		return func() {}
+	}
+
+	if _, err := UpdateState(containerId, func(s *State) error {
+		s.Health = &Health{Status: HealthStarting}
+		return nil
+	}); err != nil {
+		log.Printf("Unable to record container health: %v\n", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		startedAt := time.Now()
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			result := runHealthCheck(ctx, containerId, command, config.Timeout)
+			if ctx.Err() != nil {
+				return
+			}
+			inStartPeriod := time.Since(startedAt) < config.StartPeriod
+			previous := ""
+			state, err := UpdateState(containerId, func(s *State) error {
+				if s.Health != nil {
+					previous = s.Health.Status
+				}
+				s.Health = s.Health.record(result, config.Retries, inStartPeriod)
+				return nil
+			})
+			if err != nil {
+				log.Printf("Unable to record container health: %v\n", err)
+				continue
+			}
+			if state.Health.Status != previous {
+				emitEvent(state, "health_status", map[string]string{"healthStatus": state.Health.Status})
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// record adds result to the health log and works out the new status.
+// Failures during the start period do not count against the container.
+func (h *Health) record(result HealthResult, retries int, inStartPeriod bool) *Health {
+	if h == nil {
+		h = &Health{Status: HealthStarting}
+	}
+	h.Log = append(h.Log, result)
+	if len(h.Log) > maxHealthLog {
+		h.Log = h.Log[len(h.Log)-maxHealthLog:]
+	}
+
+	switch {
+	case result.ExitCode == 0:
+		h.Status = HealthHealthy
+		h.FailingStreak = 0
+	case inStartPeriod:
+	default:
+		h.FailingStreak++
+		if h.FailingStreak >= retries {
+			h.Status = HealthUnhealthy
+		}
+	}
+	return h
+}
+
+// runHealthCheck runs command in the container through `exec`, which
+// joins its namespaces for us, and kills it if it takes longer than
+// timeout. Like Docker, a check that times out or cannot be run at all
+// counts as a failure.
+func runHealthCheck(ctx context.Context, containerId string, command []string, timeout time.Duration) HealthResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.Command("/proc/self/exe", append([]string{"exec", containerId}, command...)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// The check's own children are in its process group too, so a check
+	// that hangs can be killed as a whole.
+	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+
+	result := HealthResult{Start: time.Now()}
+	if err := cmd.Start(); err != nil {
+		result.End = time.Now()
+		result.ExitCode = -1
+		result.Output = err.Error()
+		return result
+	}
+	waitDone := make(chan error, 1)
+	go func() { waitDone <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-waitDone:
+	case <-ctx.Done():
+		unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+		<-waitDone
+		err = fmt.Errorf("health check exceeded timeout (%v)", timeout)
+	}
+	result.End = time.Now()
+
+	out := output.String()
+	if len(out) > maxHealthOutput {
+		out = out[:maxHealthOutput]
+	}
+	result.Output = out
+	if err != nil {
+		result.ExitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.Output = err.Error()
+		}
+	}
+	return result
+}
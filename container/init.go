@@ -0,0 +1,62 @@
+package container
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// runInit is what childe-mode does with --init: rather than turning into
+// the command it stays on as the container's PID 1. It reaps every
+// process orphaned inside the container, passes the signals it gets on
+// to the command's process group and returns the command's exit status
+// once the command exits.
+func runInit(cmd *exec.Cmd) int {
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("Unable to run %s: %v\n", cmd.Args[0], err)
+		return 127
+	}
+	child := cmd.Process.Pid
+
+	for sig := range signals {
+		switch sig {
+		case unix.SIGCHLD:
+			if status, exited := reapChildren(child); exited {
+				return status
+			}
+		// The Go runtime uses SIGURG to preempt goroutines.
+		case unix.SIGURG:
+		default:
+			if err := unix.Kill(-child, sig.(unix.Signal)); err != nil && err != unix.ESRCH {
+				log.Printf("Unable to forward %v: %v\n", sig, err)
+			}
+		}
+	}
+	return 0
+}
+
+// reapChildren waits for every child that has exited so far, and reports
+// the status of child if it was among them.
+func reapChildren(child int) (int, bool) {
+	status, exited := 0, false
+	for {
+		var ws unix.WaitStatus
+		pid, err := unix.Wait4(-1, &ws, unix.WNOHANG, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return status, exited
+		}
+		if pid == child {
+			status, exited = waitStatusCode(syscall.WaitStatus(ws)), true
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sunweiwe/container/cgroup"
+	"github.com/sunweiwe/container/common"
+	"github.com/sunweiwe/container/image"
+	"github.com/sunweiwe/container/network"
+)
+
+// ContainerInspect is the full description of a container printed by
+// inspect. --format templates refer to its fields by their Go names, as
+// in {{.NetworkSettings.IPAddress}}.
+type ContainerInspect struct {
+	Id              string               `json:"id"`
+	Name            string               `json:"name"`
+	Image           string               `json:"image"`
+	ImageHash       string               `json:"imageHash"`
+	Command         []string             `json:"command"`
+	Env             []string             `json:"env"`
+	Created         time.Time            `json:"created"`
+	State           InspectState         `json:"state"`
+	HostConfig      InspectHostConfig    `json:"hostConfig"`
+	Healthcheck     *common.HealthConfig `json:"healthcheck,omitempty"`
+	CGroupPaths     []string             `json:"cgroupPaths"`
+	GraphDriver     InspectGraphDriver   `json:"graphDriver"`
+	NetworkSettings InspectNetwork       `json:"networkSettings"`
+	LogPath         string               `json:"logPath"`
+}
+
+type InspectState struct {
+	Status       Status    `json:"status"`
+	Running      bool      `json:"running"`
+	Paused       bool      `json:"paused"`
+	Restarting   bool      `json:"restarting"`
+	Pid          int       `json:"pid"`
+	ShimPid      int       `json:"shimPid"`
+	ExitCode     int       `json:"exitCode"`
+	RestartCount int       `json:"restartCount"`
+	StartedAt    time.Time `json:"startedAt"`
+	FinishedAt   time.Time `json:"finishedAt"`
+	Health       *Health   `json:"health,omitempty"`
+}
+
+type InspectHostConfig struct {
+	Resources     Resources     `json:"resources"`
+	AutoRemove    bool          `json:"autoRemove"`
+	RestartPolicy RestartPolicy `json:"restartPolicy"`
+	LogConfig     LogConfig     `json:"logConfig"`
+	Tty           bool          `json:"tty"`
+	OpenStdin     bool          `json:"openStdin"`
+	Init          bool          `json:"init"`
+}
+
+type InspectGraphDriver struct {
+	Name      string   `json:"name"`
+	LowerDir  []string `json:"lowerDir"`
+	UpperDir  string   `json:"upperDir"`
+	WorkDir   string   `json:"workDir"`
+	MergedDir string   `json:"mergedDir"`
+}
+
+// InspectNetwork describes the container's network. The addresses are
+// only known while its network namespace exists, from create until the
+// container exits.
+type InspectNetwork struct {
+	SandboxKey    string `json:"sandboxKey"`
+	Bridge        string `json:"bridge"`
+	HostVeth      string `json:"hostVeth"`
+	ContainerVeth string `json:"containerVeth"`
+	IPAddress     string `json:"ipAddress"`
+	MacAddress    string `json:"macAddress"`
+	Gateway       string `json:"gateway"`
+}
+
+// InspectContainer gathers everything known about a container.
+func InspectContainer(containerId string) (*ContainerInspect, error) {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return nil, fmt.Errorf("no such container: %s", containerId)
+	}
+	imgConfig := image.ParseContainerConfig(state.ImageHash)
+	fsHome := containerHome(containerId) + "/fs"
+
+	info := &ContainerInspect{
+		Id:        state.Id,
+		Name:      state.Name,
+		Image:     state.Image,
+		ImageHash: state.ImageHash,
+		Command:   state.Command,
+		Env:       containerEnv(state, imgConfig.Config),
+		Created:   state.Created,
+		State: InspectState{
+			Status:       state.Status,
+			Running:      state.IsRunning(),
+			Paused:       state.Status == StatusPaused,
+			Restarting:   state.Status == StatusRestarting,
+			Pid:          state.Pid,
+			ShimPid:      state.ShimPid,
+			ExitCode:     state.ExitCode,
+			RestartCount: state.RestartCount,
+			StartedAt:    state.StartedAt,
+			FinishedAt:   state.FinishedAt,
+			Health:       state.Health,
+		},
+		HostConfig: InspectHostConfig{
+			Resources:     state.Resources,
+			AutoRemove:    state.AutoRemove,
+			RestartPolicy: state.RestartPolicy,
+			LogConfig:     state.LogConfig,
+			Tty:           state.Tty,
+			OpenStdin:     state.OpenStdin,
+			Init:          state.Init,
+		},
+		Healthcheck: state.Healthcheck,
+		CGroupPaths: cgroup.GetCGroupPaths(containerId),
+		GraphDriver: InspectGraphDriver{
+			Name:      "overlay",
+			UpperDir:  fsHome + "/upperdir",
+			WorkDir:   fsHome + "/workdir",
+			MergedDir: fsHome + "/mnt",
+		},
+		NetworkSettings: InspectNetwork{
+			SandboxKey:    netNsBasePath + "/" + containerId,
+			Bridge:        "container0",
+			HostVeth:      network.HostVethName(containerId),
+			ContainerVeth: network.ContainerVethName(containerId),
+			Gateway:       "172.29.0.1",
+		},
+		LogPath: logPath(containerId),
+	}
+	// The image may have been removed by hand; the rest still stands.
+	if lowerDirs, err := overlayLowerDirs(state.ImageHash); err == nil {
+		info.GraphDriver.LowerDir = lowerDirs
+	}
+	if state.Status != StatusExited {
+		info.NetworkSettings.IPAddress, info.NetworkSettings.MacAddress, _ = network.GetContainerAddress(containerId)
+	}
+	return info, nil
+}
+
+// PrintInspect prints the containers as a JSON array or, given a Go
+// template, the template applied to each container in turn.
+func PrintInspect(containerIds []string, format string) error {
+	var infos []*ContainerInspect
+	for _, containerId := range containerIds {
+		info, err := InspectContainer(containerId)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+	}
+
+	if len(format) == 0 {
+		data, err := json.MarshalIndent(infos, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	tmpl, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"join": strings.Join,
+	}).Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid format: %v", err)
+	}
+	for _, info := range infos {
+		if err := tmpl.Execute(os.Stdout, info); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package container
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/sunweiwe/container/tty"
+	"golang.org/x/sys/unix"
+)
+
+// containerIO is what a supervisor connects the container's standard
+// streams to. Output always goes to the container log and to attached
+// clients; Stdout and Stderr, when set, get a copy of it as well. A nil
+// Stdin reads from /dev/null.
+//
+// A container with a terminal has no separate streams: everything goes
+// through the master side of its pty, the console, which is replaced
+// each time the container is restarted.
+type containerIO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	log    *jsonLogWriter
+	attach *attachServer
+
+	mu      sync.Mutex
+	console *os.File
+	size    *unix.Winsize
+	// stdinPipe feeds the stdin of a container without a terminal from
+	// attached clients. It is only set for detached containers run with
+	// -i; in the foreground stdin is our own.
+	stdinPipe *os.File
+	stdinOnce sync.Once
+}
+
+// outputs returns the writers for one run of the container process, and a
+// function that flushes any unterminated last line to the log once the
+// process is gone.
+func (c *containerIO) outputs() (io.Writer, io.Writer, func()) {
+	var stdout, stderr []io.Writer
+	if c.Stdout != nil {
+		stdout = append(stdout, c.Stdout)
+	}
+	if c.Stderr != nil {
+		stderr = append(stderr, c.Stderr)
+	}
+	if c.attach != nil {
+		stdout = append(stdout, c.attach.stream(frameStdout))
+		stderr = append(stderr, c.attach.stream(frameStderr))
+	}
+
+	flush := func() {}
+	if c.log != nil {
+		stdoutLog, stderrLog := c.log.stream("stdout"), c.log.stream("stderr")
+		stdout = append(stdout, stdoutLog)
+		stderr = append(stderr, stderrLog)
+		flush = func() {
+			stdoutLog.Close()
+			stderrLog.Close()
+		}
+	}
+	return multiWriter(stdout), multiWriter(stderr), flush
+}
+
+// sharesTerminal reports whether the container reads our terminal as its
+// own stdin, which keeps it in the terminal's foreground process group.
+// A container with a pty of its own never does.
+func (c *containerIO) sharesTerminal(hasTty bool) bool {
+	stdin, ok := c.Stdin.(*os.File)
+	return ok && !hasTty && tty.IsTerminal(stdin.Fd())
+}
+
+func multiWriter(writers []io.Writer) io.Writer {
+	switch len(writers) {
+	case 0:
+		return io.Discard
+	case 1:
+		return writers[0]
+	}
+	return io.MultiWriter(writers...)
+}
+
+// setConsole switches the container's terminal to console, or drops it
+// when console is nil. The first console also starts copying Stdin to
+// whichever console is current.
+func (c *containerIO) setConsole(console *os.File) {
+	c.mu.Lock()
+	c.console = console
+	size := c.size
+	c.mu.Unlock()
+
+	if console == nil {
+		return
+	}
+	if size != nil {
+		if err := tty.SetWinsize(console.Fd(), size); err != nil {
+			log.Printf("Unable to resize container terminal: %v\n", err)
+		}
+	}
+	if c.Stdin != nil {
+		c.stdinOnce.Do(func() { go c.copyStdin() })
+	}
+}
+
+// Resize sets the window size of the container's terminal, now and for
+// any console it gets after a restart.
+func (c *containerIO) Resize(ws *unix.Winsize) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = ws
+	if c.console == nil {
+		return nil
+	}
+	return tty.SetWinsize(c.console.Fd(), ws)
+}
+
+// writeInput passes input to the container: to its console if it has a
+// terminal, otherwise to its stdin pipe. Input arriving while the
+// container is being restarted, or for a container that does not keep
+// stdin open, is dropped.
+func (c *containerIO) writeInput(p []byte) {
+	c.mu.Lock()
+	input := c.stdinPipe
+	if c.console != nil {
+		input = c.console
+	}
+	c.mu.Unlock()
+
+	if input != nil {
+		input.Write(p)
+	}
+}
+
+func (c *containerIO) copyStdin() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.Stdin.Read(buf)
+		if n > 0 {
+			c.writeInput(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
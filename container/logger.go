@@ -0,0 +1,199 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogConfig limits how much output is kept for a container. The log is
+// rotated once it reaches MaxSize bytes and at most MaxFile files are
+// kept, the live one included. A MaxSize of zero means no rotation.
+type LogConfig struct {
+	MaxSize int64 `json:"maxSize,omitempty"`
+	MaxFile int   `json:"maxFile,omitempty"`
+}
+
+// logEntry is one line of container output in the log file, in the same
+// JSON-lines layout Docker's json-file driver uses.
+type logEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func logPath(containerId string) string {
+	return containerHome(containerId) + "/container.log"
+}
+
+// ParseLogOptions reads `--log-opt key=value` options. Only max-size
+// (with an optional k, m or g suffix) and max-file are understood.
+func ParseLogOptions(opts []string) (LogConfig, error) {
+	config := LogConfig{MaxFile: 1}
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return config, fmt.Errorf("invalid log option %q, expected key=value", opt)
+		}
+		switch key {
+		case "max-size":
+			size, err := parseSize(value)
+			if err != nil {
+				return config, err
+			}
+			config.MaxSize = size
+		case "max-file":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return config, fmt.Errorf("invalid max-file: %s", value)
+			}
+			config.MaxFile = count
+		default:
+			return config, fmt.Errorf("unknown log option: %s", key)
+		}
+	}
+	if config.MaxFile > 1 && config.MaxSize == 0 {
+		return config, fmt.Errorf("max-file requires max-size to be set")
+	}
+	return config, nil
+}
+
+func parseSize(size string) (int64, error) {
+	units := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+	s := strings.TrimSuffix(strings.ToLower(size), "b")
+	multiplier := int64(1)
+	if len(s) > 0 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			multiplier = unit
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return n * multiplier, nil
+}
+
+// jsonLogWriter appends entries to a container's log file and rotates it
+// as its LogConfig says. It is shared by the stdout and stderr streams.
+type jsonLogWriter struct {
+	mu     sync.Mutex
+	path   string
+	config LogConfig
+	file   *os.File
+	size   int64
+}
+
+func newJSONLogWriter(path string, config LogConfig) (*jsonLogWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &jsonLogWriter{path: path, config: config, file: file, size: info.Size()}, nil
+}
+
+func (w *jsonLogWriter) writeEntry(stream string, line []byte) error {
+	data, err := json.Marshal(logEntry{Log: string(line), Stream: stream, Time: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.config.MaxSize > 0 && w.size > 0 && w.size+int64(len(data)) > w.config.MaxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+// rotate shifts container.log.N-1 to container.log.N and so on down to
+// container.log, dropping whatever falls beyond MaxFile.
+func (w *jsonLogWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if w.config.MaxFile > 1 {
+		for i := w.config.MaxFile - 1; i > 1; i-- {
+			os.Rename(w.path+"."+strconv.Itoa(i-1), w.path+"."+strconv.Itoa(i))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	return nil
+}
+
+func (w *jsonLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// stream returns a writer that turns everything written to it into log
+// entries for the given stream, one per line.
+func (w *jsonLogWriter) stream(name string) *logStreamWriter {
+	return &logStreamWriter{log: w, name: name}
+}
+
+// Output without a newline is logged in pieces of this size rather than
+// buffered without bound.
+const maxLogLine = 16 * 1024
+
+type logStreamWriter struct {
+	log  *jsonLogWriter
+	name string
+	buf  []byte
+}
+
+// Write never fails: losing a log line is better than handing the
+// container a broken pipe.
+func (s *logStreamWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 && len(s.buf) < maxLogLine {
+			break
+		}
+		if i < 0 {
+			i = maxLogLine - 1
+		}
+		if err := s.log.writeEntry(s.name, s.buf[:i+1]); err != nil {
+			log.Printf("Unable to write container log: %v\n", err)
+		}
+		s.buf = s.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close logs whatever is left after the last newline.
+func (s *logStreamWriter) Close() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.log.writeEntry(s.name, s.buf)
+	s.buf = nil
+	return err
+}
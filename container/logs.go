@@ -0,0 +1,162 @@
+package container
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LogsOptions select what `logs` prints. A negative Tail prints every
+// line, and a zero Since does not filter on time.
+type LogsOptions struct {
+	Follow     bool
+	Tail       int
+	Since      time.Time
+	Timestamps bool
+}
+
+// ParseLogTime reads the --since argument: an RFC 3339 timestamp or date,
+// a Unix timestamp, or a duration such as 10m that counts back from now.
+func ParseLogTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", value)
+}
+
+// PrintLogs writes the container's logged output to our stdout and
+// stderr, following the log while the container is running if asked to.
+func PrintLogs(containerId string, opts LogsOptions) error {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return err
+	}
+
+	// Rotated files first, oldest to newest, then the live log.
+	var entries []logEntry
+	for i := state.LogConfig.MaxFile - 1; i >= 1; i-- {
+		file, err := os.Open(logPath(containerId) + "." + strconv.Itoa(i))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		rotated := &logReader{file: file, reader: bufio.NewReader(file)}
+		entries = append(entries, rotated.readEntries()...)
+		file.Close()
+	}
+
+	live, err := openLogReader(containerId)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { live.file.Close() }()
+	entries = append(entries, live.readEntries()...)
+
+	if !opts.Since.IsZero() {
+		var recent []logEntry
+		for _, e := range entries {
+			if !e.Time.Before(opts.Since) {
+				recent = append(recent, e)
+			}
+		}
+		entries = recent
+	}
+	if opts.Tail >= 0 && len(entries) > opts.Tail {
+		entries = entries[len(entries)-opts.Tail:]
+	}
+	for _, e := range entries {
+		printLogEntry(e, opts.Timestamps)
+	}
+
+	if !opts.Follow {
+		return nil
+	}
+	for {
+		if newEntries := live.readEntries(); len(newEntries) > 0 {
+			for _, e := range newEntries {
+				printLogEntry(e, opts.Timestamps)
+			}
+			continue
+		}
+
+		// The log was rotated under us: finish the old file, which we
+		// just did, and carry on with the new one from the start.
+		if info, err := os.Stat(logPath(containerId)); err == nil {
+			if current, err := live.file.Stat(); err == nil && !os.SameFile(info, current) {
+				live.file.Close()
+				if live, err = openLogReader(containerId); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		state, err := LoadState(containerId)
+		if err != nil || !(state.IsRunning() || state.Status == StatusRestarting) {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func printLogEntry(e logEntry, timestamps bool) {
+	out := os.Stdout
+	if e.Stream == "stderr" {
+		out = os.Stderr
+	}
+	if timestamps {
+		fmt.Fprintf(out, "%s %s", e.Time.Format(time.RFC3339Nano), e.Log)
+	} else {
+		fmt.Fprint(out, e.Log)
+	}
+}
+
+// logReader reads entries from a log file that may still be written to.
+// A line that has not been completely written yet is kept back until the
+// rest of it shows up.
+type logReader struct {
+	file    *os.File
+	reader  *bufio.Reader
+	pending []byte
+}
+
+func openLogReader(containerId string) (*logReader, error) {
+	file, err := os.Open(logPath(containerId))
+	if err != nil {
+		return nil, err
+	}
+	return &logReader{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+func (r *logReader) readEntries() []logEntry {
+	var entries []logEntry
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		r.pending = append(r.pending, line...)
+		if err != nil {
+			return entries
+		}
+
+		var e logEntry
+		if json.Unmarshal(r.pending, &e) == nil {
+			entries = append(entries, e)
+		}
+		r.pending = nil
+	}
+}
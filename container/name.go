@@ -0,0 +1,97 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var validContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ResolveContainerId turns what the user typed into a container ID. It
+// accepts a full ID, a name, or a prefix of an ID as long as only one
+// container matches it.
+func ResolveContainerId(ref string) (string, error) {
+	states, err := ListStates()
+	if err != nil {
+		return "", err
+	}
+
+	for _, s := range states {
+		if s.Id == ref || (len(s.Name) > 0 && s.Name == ref) {
+			return s.Id, nil
+		}
+	}
+
+	var matches []string
+	for _, s := range states {
+		if strings.HasPrefix(s.Id, ref) {
+			matches = append(matches, s.Id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no such container: %s", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("multiple containers match %q: %s", ref, strings.Join(matches, ", "))
+	}
+}
+
+// RenameContainer gives a container a new name.
+func RenameContainer(containerId string, name string) error {
+	return withNamesLock(func() error {
+		if err := checkNameAvailable(name); err != nil {
+			return err
+		}
+		oldName := ""
+		state, err := UpdateState(containerId, func(s *State) error {
+			oldName = s.Name
+			s.Name = name
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		emitEvent(state, "rename", map[string]string{"oldName": oldName})
+		return nil
+	})
+}
+
+// checkNameAvailable must be called with the names lock held, and the
+// name only counts as taken once the state carrying it is saved.
+func checkNameAvailable(name string) error {
+	if !validContainerName.MatchString(name) {
+		return fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	states, err := ListStates()
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		if s.Name == name {
+			return fmt.Errorf("the container name %q is already in use by container %s", name, s.Id)
+		}
+	}
+	return nil
+}
+
+// withNamesLock serialises the check for a free name with saving it, so
+// that two containers cannot claim the same name at once.
+func withNamesLock(fn func() error) error {
+	lockFile, err := os.OpenFile(containersBasePath+"/names.lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	if err := unix.Flock(int(lockFile.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+	defer unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+
+	return fn()
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"fmt"
+
+	"github.com/sunweiwe/container/cgroup"
+)
+
+// PauseContainer freezes every process of a running container.
+func PauseContainer(containerId string) error {
+	state, err := UpdateState(containerId, func(s *State) error {
+		if s.Status != StatusRunning {
+			return fmt.Errorf("container %s is not running", containerId)
+		}
+		if err := cgroup.FreezeCGroup(containerId); err != nil {
+			return err
+		}
+		s.Status = StatusPaused
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	emitEvent(state, "pause", nil)
+	return nil
+}
+
+// UnpauseContainer thaws a container frozen by PauseContainer.
+func UnpauseContainer(containerId string) error {
+	state, err := UpdateState(containerId, func(s *State) error {
+		if s.Status != StatusPaused {
+			return fmt.Errorf("container %s is not paused", containerId)
+		}
+		if err := cgroup.ThawCGroup(containerId); err != nil {
+			return err
+		}
+		s.Status = StatusRunning
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	emitEvent(state, "unpause", nil)
+	return nil
+}
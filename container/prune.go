@@ -0,0 +1,227 @@
+package container
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sunweiwe/container/cgroup"
+	"github.com/sunweiwe/container/network"
+	"github.com/sunweiwe/container/utils"
+)
+
+const (
+	netNsBasePath  = "/var/run/container/net-ns"
+	imagesTmpPath  = "/var/lib/container/tmp"
+	vethNamePrefix = 6
+)
+
+// pruneAction is one orphaned resource and how to get rid of it.
+type pruneAction struct {
+	what   string
+	remove func() error
+}
+
+// PruneSystem removes what crashed runs leave behind: overlay mounts,
+// network namespaces, veth links and empty cgroups that no container owns
+// any more, and unfinished image pulls. A container owns its resources
+// from create until it exits; after that they are all orphans. With
+// dryRun the orphans are only listed.
+func PruneSystem(dryRun bool) error {
+	owners, err := resourceOwners()
+	if err != nil {
+		return err
+	}
+
+	var actions []pruneAction
+	collectors := []func(map[string]bool) ([]pruneAction, error){
+		orphanedMounts,
+		orphanedNetworkNamespaces,
+		orphanedVeths,
+		orphanedCGroups,
+		unfinishedPulls,
+	}
+	for _, collect := range collectors {
+		found, err := collect(owners)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, found...)
+	}
+
+	failed := 0
+	for _, action := range actions {
+		if dryRun {
+			fmt.Printf("Would remove %s\n", action.what)
+			continue
+		}
+		if err := action.remove(); err != nil {
+			log.Printf("Unable to remove %s: %v\n", action.what, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Removed %s\n", action.what)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d resources could not be removed", failed, len(actions))
+	}
+	return nil
+}
+
+// resourceOwners returns the ids of the containers that may hold mounts,
+// links and cgroups: every container that has not exited. The veth link
+// names only carry the first few characters of the id, so those are in
+// the set as well.
+func resourceOwners() (map[string]bool, error) {
+	states, err := ListStates()
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]bool{}
+	for _, state := range states {
+		if state.Status != StatusExited {
+			owners[state.Id] = true
+			if len(state.Id) >= vethNamePrefix {
+				owners[state.Id[:vethNamePrefix]] = true
+			}
+		}
+	}
+	return owners, nil
+}
+
+func orphanedMounts(owners map[string]bool) ([]pruneAction, error) {
+	mountPoints, err := listMountPoints()
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []pruneAction
+	for _, mountPoint := range mountPoints {
+		if !strings.HasPrefix(mountPoint, containersBasePath+"/") || !strings.HasSuffix(mountPoint, "/fs/mnt") {
+			continue
+		}
+		containerId := strings.TrimSuffix(strings.TrimPrefix(mountPoint, containersBasePath+"/"), "/fs/mnt")
+		if owners[containerId] {
+			continue
+		}
+		actions = append(actions, pruneAction{
+			what:   "overlay mount " + mountPoint,
+			remove: func() error { return unmountContainerFs(containerId) },
+		})
+	}
+	return actions, nil
+}
+
+func orphanedNetworkNamespaces(owners map[string]bool) ([]pruneAction, error) {
+	entries, err := os.ReadDir(netNsBasePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var actions []pruneAction
+	for _, entry := range entries {
+		containerId := entry.Name()
+		if owners[containerId] {
+			continue
+		}
+		actions = append(actions, pruneAction{
+			what:   "network namespace " + netNsBasePath + "/" + containerId,
+			remove: func() error { return unmountNetworkNamespace(containerId) },
+		})
+	}
+	return actions, nil
+}
+
+func orphanedVeths(owners map[string]bool) ([]pruneAction, error) {
+	prefixes, err := network.ListVirtualEthOnHost()
+	if err != nil {
+		return nil, err
+	}
+	var actions []pruneAction
+	for _, prefix := range prefixes {
+		if owners[prefix] || len(prefix) < vethNamePrefix {
+			continue
+		}
+		prefix := prefix
+		actions = append(actions, pruneAction{
+			what:   "veth link veth0_" + prefix,
+			remove: func() error { return network.RemoveVirtualEthOnHost(prefix) },
+		})
+	}
+	return actions, nil
+}
+
+// orphanedCGroups only returns empty cgroups: processes still running in
+// one are beyond what prune should decide about.
+func orphanedCGroups(owners map[string]bool) ([]pruneAction, error) {
+	containerIds, err := cgroup.ListCGroups()
+	if err != nil {
+		return nil, err
+	}
+	var actions []pruneAction
+	for _, containerId := range containerIds {
+		if owners[containerId] {
+			continue
+		}
+		if pids, _ := cgroup.GetCGroupPids(containerId); len(pids) > 0 {
+			log.Printf("Keeping cgroup of %s: %d processes are still in it\n", containerId, len(pids))
+			continue
+		}
+		containerId := containerId
+		actions = append(actions, pruneAction{
+			what:   "cgroup of " + containerId,
+			remove: func() error { return cgroup.RemoveCGroups(containerId) },
+		})
+	}
+	return actions, nil
+}
+
+func unfinishedPulls(map[string]bool) ([]pruneAction, error) {
+	entries, err := os.ReadDir(imagesTmpPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var actions []pruneAction
+	for _, entry := range entries {
+		path := imagesTmpPath + "/" + entry.Name()
+		// Pulls, commits and imports under way hold a lock on what they
+		// are writing.
+		lock, err := utils.TryLockPath(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if lock == nil {
+			continue
+		}
+		lock.Close()
+		actions = append(actions, pruneAction{
+			what:   "image pull directory " + path,
+			remove: func() error { return removeUnlocked(path) },
+		})
+	}
+	return actions, nil
+}
+
+// removeUnlocked removes path from the temporary image directory unless
+// somebody has locked it. Holding the directory itself keeps new entries
+// from being made meanwhile, which are only unlocked until they are.
+func removeUnlocked(path string) error {
+	tmpLock, err := utils.LockPath(imagesTmpPath)
+	if err != nil {
+		return err
+	}
+	defer tmpLock.Close()
+
+	lock, err := utils.TryLockPath(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil || lock == nil {
+		return err
+	}
+	defer lock.Close()
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,103 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RestartNo            = "no"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+	RestartOnFailure     = "on-failure"
+)
+
+const (
+	restartBackoffMin = 100 * time.Millisecond
+	restartBackoffMax = time.Minute
+	// A run that lasted longer than this resets the backoff.
+	restartBackoffReset = 10 * time.Second
+)
+
+// RestartPolicy tells the supervisor what to do when the container
+// process exits. MaximumRetryCount only applies to on-failure, zero
+// meaning no limit.
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximumRetryCount,omitempty"`
+}
+
+// ParseRestartPolicy accepts no, always, unless-stopped, on-failure and
+// on-failure:<max>.
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	name, max, hasMax := strings.Cut(policy, ":")
+	switch name {
+	case "", RestartNo:
+		name = RestartNo
+	case RestartAlways, RestartUnlessStopped, RestartOnFailure:
+	default:
+		return RestartPolicy{}, fmt.Errorf("invalid restart policy: %s", policy)
+	}
+
+	p := RestartPolicy{Name: name}
+	if hasMax {
+		if name != RestartOnFailure {
+			return RestartPolicy{}, fmt.Errorf("maximum retry count is only valid for %s", RestartOnFailure)
+		}
+		count, err := strconv.Atoi(max)
+		if err != nil || count < 0 {
+			return RestartPolicy{}, fmt.Errorf("invalid maximum retry count: %s", max)
+		}
+		p.MaximumRetryCount = count
+	}
+	return p, nil
+}
+
+// shouldRestart decides, once the container process has exited, whether
+// the supervisor should start it again. Without a daemon that outlives
+// reboots, always and unless-stopped behave the same: both give up once
+// the user stopped the container.
+func (s *State) shouldRestart() bool {
+	if s.StoppedByUser {
+		return false
+	}
+	switch s.RestartPolicy.Name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		if s.ExitCode == 0 {
+			return false
+		}
+		return s.RestartPolicy.MaximumRetryCount == 0 || s.RestartCount < s.RestartPolicy.MaximumRetryCount
+	default:
+		return false
+	}
+}
+
+// sleepBackoff waits before the next restart, cutting the wait short if
+// the user stops the container meanwhile, so that stop and rm -f need not
+// wait out a backoff of up to restartBackoffMax.
+func sleepBackoff(containerId string, backoff time.Duration) {
+	deadline := time.Now().Add(backoff)
+	for time.Now().Before(deadline) {
+		if state, err := LoadState(containerId); err != nil || state.StoppedByUser {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// nextBackoff doubles the delay before the next restart, starting over
+// if the last run stayed up for a while.
+func nextBackoff(backoff time.Duration, ran time.Duration) time.Duration {
+	if ran > restartBackoffReset || backoff == 0 {
+		return restartBackoffMin
+	}
+	backoff *= 2
+	if backoff > restartBackoffMax {
+		backoff = restartBackoffMax
+	}
+	return backoff
+}
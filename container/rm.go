@@ -0,0 +1,55 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// RemoveContainer tears down whatever is left of a container and deletes
+// its directory. Each step skips resources that are already gone, so a
+// removal that failed half way can simply be run again. A running
+// container is refused unless force is set, in which case it is stopped
+// first.
+func RemoveContainer(containerId string, force bool) error {
+	// The id is joined onto paths below; anything else could point
+	// outside the container directory.
+	if !IsContainerId(containerId) {
+		return fmt.Errorf("invalid container id: %s", containerId)
+	}
+	if _, err := os.Stat(containerHome(containerId)); os.IsNotExist(err) {
+		return fmt.Errorf("no such container: %s", containerId)
+	}
+
+	// A container that never got as far as saving its state can still
+	// have mounts and links lying around, so carry on without one.
+	state, err := LoadState(containerId)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if state != nil && (state.IsRunning() || state.Status == StatusRestarting) {
+		if !force {
+			return fmt.Errorf("container %s is running: stop it first or use -f", containerId)
+		}
+		if err := StopContainer(containerId, 0); err != nil {
+			return err
+		}
+		// Let the supervisor finish its own teardown before we pull the
+		// container directory out from under it. Stopping cuts its
+		// restart backoff short, but allow for a whole one.
+		if state.ShimPid > 0 && !waitForExit(state.ShimPid, restartBackoffMax+10*time.Second) {
+			return fmt.Errorf("supervisor of container %s did not exit", containerId)
+		}
+	}
+
+	if err := teardownContainer(containerId); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(containerHome(containerId)); err != nil {
+		return err
+	}
+	if state != nil {
+		emitEvent(state, "destroy", nil)
+	}
+	return nil
+}
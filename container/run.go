@@ -2,16 +2,22 @@
 package container
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/sunweiwe/container/cgroup"
 	"github.com/sunweiwe/container/common"
 	"github.com/sunweiwe/container/image"
 	"github.com/sunweiwe/container/network"
+	"github.com/sunweiwe/container/tty"
 	"github.com/sunweiwe/container/utils"
 	"golang.org/x/sys/unix"
 )
@@ -21,91 +27,66 @@ import (
 // pids
 // cups
 // 初始化
-// 1. 创建容器 id
-// 2. 下载 image
-// 3. 创建容器工作目录
-// 4. 挂载文件 overlay 方式
-// 5. 创建 veth pair
-// 6. 创建 netns
-// 7. 挂载 veth
-func InitContainer(imageName string, memory int, swap int, pids int, cpus float64, args []string) {
-	containerId := CreateContainerId()
-	log.Printf("New container ID: %s\n", containerId)
+// 1. 创建容器 (见 CreateContainer)
+// 2. 启动容器进程 (见 StartContainer)
+// 3. 前台运行时返回容器的退出码
+func InitContainer(opts CreateOptions, detach bool) int {
+	containerId, err := CreateContainer(opts)
+	utils.DoOrDieWithMessage(err, "Unable to create container")
 
-	// imageHash
-	imageHash := image.DownloadImageIfRequired(imageName)
-	log.Printf("Image to overlay mount: %s\n", imageHash)
-
-	// create container directories
-	createContainerDirectories(containerId)
-	// 挂载容器文件系统 overlay
-	mountOverlayFileSystem(containerId, imageHash)
-
-	// 设置网络 eth
-	if err := network.SetUpVirtualEthOnHost(containerId); err != nil {
-		log.Fatalf("Unable to setup eth0 on host %v", err)
+	exitCode, err := StartContainer(containerId, !detach)
+	utils.DoOrDieWithMessage(err, "Unable to start container")
+	if detach {
+		fmt.Println(containerId)
 	}
-
-	// 创建 namespace ，通过ns
-	prepareAndExecuteContainer(memory, swap, pids, cpus, containerId, imageHash, args)
-	log.Fatalf("Container done.\n")
-
-	unmountNetworkNamespace(containerId)
-	unmountContainerFs(containerId)
-	cgroup.RemoveCGroups(containerId)
-	os.RemoveAll("/var/run/container/containers/" + containerId)
+	return exitCode
 }
 
-func createContainerDirectories(containerId string) {
-	containerHome := "/var/run/container/containers/" + containerId + "/fs"
-	containerDirs := []string{containerHome, containerHome + "/mnt", containerHome + "/upperdir", containerHome + "/workdir"}
-	if err := utils.CreateDirsIfNotExist(containerDirs); err != nil {
-		log.Fatalf("Unable to create required directories: %v\n", err)
+// teardownContainer releases the mounts, network and cgroups InitContainer
+// set up. Every step tolerates resources that are already gone, so it is
+// safe to run more than once. The container directory and its state are
+// kept so that the container still shows up in `ps -a`.
+func teardownContainer(containerId string) error {
+	steps := []struct {
+		name string
+		undo func(string) error
+	}{
+		{"unmount container fs", unmountContainerFs},
+		{"unmount network namespace", unmountNetworkNamespace},
+		{"remove veth", network.RemoveVirtualEthOnHost},
+		{"remove cgroups", cgroup.RemoveCGroups},
 	}
-}
 
-func mountOverlayFileSystem(containerId string, imageHash string) {
-	var srcLayers []string
-	pathManifest := "/var/lib/container/images/" + imageHash + "/" + imageHash + ".json"
-	mani := common.Manifest{}
-	utils.ParseManifest(pathManifest, &mani)
-	if len(mani) == 0 || len(mani[0].Layers) == 0 {
-		log.Fatal("Could not find any layer.")
-	}
-	if len(mani) > 1 {
-		log.Fatal("I don't know how to handle more than one manifest.")
+	var firstErr error
+	for _, step := range steps {
+		if err := step.undo(containerId); err != nil {
+			log.Printf("Unable to %s: %v\n", step.name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
+	return firstErr
+}
 
-	imageBasePath := "/var/lib/container/images/" + imageHash
-	for _, layer := range mani[0].Layers {
-		srcLayers = append([]string{imageBasePath + "/" + layer[:12] + "/fs"}, srcLayers...)
-	}
+// releaseContainer is run by whoever supervised the container once its
+// process is gone. Containers started with --rm are removed entirely.
+func releaseContainer(containerId string) {
+	teardownContainer(containerId)
 
-	containerFsHome := "/var/run/container/containers/" + containerId + "/fs"
-	mntOptions := "lowerdir=" + strings.Join(srcLayers, ":") + ",upperdir=" + containerFsHome + "/upperdir,workdir=" + containerFsHome + "/workdir"
-	if err := unix.Mount("none", containerFsHome+"/mnt", "overlay", 0, mntOptions); err != nil {
-		log.Fatalf("Mount failed: %v\n", err)
+	state, err := LoadState(containerId)
+	if err != nil {
+		log.Printf("Unable to load container state: %v\n", err)
+		return
 	}
-}
-
-func prepareAndExecuteContainer(memory int, swap int, pids int, cpus float64, containerId string, imageHash string, cmdArgs []string) {
-	// setup the network namespace
-	cmd := &exec.Cmd{
-		Path:   "/proc/self/exe",
-		Args:   []string{"/proc/self/exe", "setup-netns", containerId},
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
+	if state.AutoRemove {
+		if err := os.RemoveAll(containerHome(containerId)); err != nil {
+			log.Printf("Unable to remove container directory: %v\n", err)
+		}
 	}
-	cmd.Run()
+}
 
-	// Namespace and setup the virtual interface
-	cmd = &exec.Cmd{
-		Path:   "/proc/self/exe",
-		Args:   []string{"/proc/self/exe", "setup-veth", containerId},
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
-	}
-	cmd.Run()
+func prepareAndExecuteContainer(containerId string, stdio *containerIO) error {
 	/*
 		From namespaces(7)
 			Namespace Flag            Isolates
@@ -123,51 +104,122 @@ func prepareAndExecuteContainer(memory int, swap int, pids int, cpus float64, co
 			UTS       CLONE_NEWUTS    Hostname and NIS
 		                                 domain name
 	*/
-	var opts []string
-	if memory > 0 {
-		opts = append(opts, "--mem="+strconv.Itoa(memory))
+	state, err := LoadState(containerId)
+	if err != nil {
+		return err
 	}
 
-	if swap >= 0 {
-		opts = append(opts, "--swaps="+strconv.Itoa(swap))
+	stdout, stderr, flushLog := stdio.outputs()
+	defer flushLog()
+
+	cmd := exec.Command("/proc/self/exe", "childe-mode", containerId)
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
+	cmd.SysProcAttr = &unix.SysProcAttr{
+		Cloneflags: unix.CLONE_NEWPID | unix.CLONE_NEWNS | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC,
+		// Signals meant for us, Ctrl-C included, reach the container only
+		// through forwardSignals, unless it has to read our terminal. With
+		// a tty the child starts a session of its own, which it could not
+		// do as a process group leader.
+		Setpgid: !state.Tty && !stdio.sharesTerminal(state.Tty),
 	}
 
-	if pids > 0 {
-		opts = append(opts, "--pids="+strconv.Itoa(pids))
+	// With a terminal, the pty is allocated inside the container and its
+	// master side comes back to us over this socket; stdin then goes to
+	// the console instead of to the child.
+	var consoleSocket, childSocket *os.File
+	if state.Tty {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		consoleSocket = os.NewFile(uintptr(fds[0]), "console-socket")
+		childSocket = os.NewFile(uintptr(fds[1]), "console-socket")
+		defer consoleSocket.Close()
+		cmd.ExtraFiles = []*os.File{childSocket}
+	} else {
+		cmd.Stdin = stdio.Stdin
 	}
 
-	if cpus > 0 {
-		opts = append(opts, "--cpu="+strconv.FormatFloat(cpus, 'f', 1, 64))
+	err = cmd.Start()
+	if childSocket != nil {
+		childSocket.Close()
+	}
+	if err != nil {
+		return recordStartFailure(state, err)
 	}
 
-	opts = append(opts, "--image="+imageHash)
-	args := append([]string{containerId}, cmdArgs...)
-	args = append(opts, args...)
-	args = append([]string{"childe-mode"}, args...)
-	cmd = exec.Command("/proc/self/exe", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.SysProcAttr = &unix.SysProcAttr{
-		Cloneflags: unix.CLONE_NEWPID | unix.CLONE_NEWNS | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC,
+	oomKills, _ := cgroup.GetOOMKillCount(containerId)
+	if _, err := UpdateState(containerId, func(s *State) error {
+		s.Pid = cmd.Process.Pid
+		s.Status = StatusRunning
+		s.StartedAt = time.Now()
+		return nil
+	}); err != nil {
+		log.Printf("Unable to record container start: %v\n", err)
 	}
+	emitEvent(state, "start", nil)
+	stopHealthMonitor := startHealthMonitor(containerId, state.Healthcheck)
 
-	utils.DoOrDie(cmd.Run())
+	var consoleDone chan struct{}
+	if state.Tty {
+		console, err := tty.RecvFd(consoleSocket)
+		if err != nil {
+			log.Printf("Unable to receive container terminal: %v\n", err)
+		} else {
+			stdio.setConsole(console)
+			consoleDone = make(chan struct{})
+			go func() {
+				// Reading the console fails with EIO once the last process
+				// holding the terminal has gone.
+				io.Copy(stdout, console)
+				close(consoleDone)
+			}()
+			defer func() {
+				stdio.setConsole(nil)
+				console.Close()
+			}()
+		}
+	}
+
+	waitErr := cmd.Wait()
+	stopHealthMonitor()
+	if consoleDone != nil {
+		<-consoleDone
+	}
+	if _, err := UpdateState(containerId, func(s *State) error {
+		s.Status = StatusExited
+		s.ExitCode = ExitStatus(cmd.ProcessState)
+		s.FinishedAt = time.Now()
+		return nil
+	}); err != nil {
+		log.Printf("Unable to record container exit: %v\n", err)
+	}
+	if n, err := cgroup.GetOOMKillCount(containerId); err == nil && n > oomKills {
+		emitEvent(state, "oom", nil)
+	}
+	emitEvent(state, "die", map[string]string{"exitCode": strconv.Itoa(ExitStatus(cmd.ProcessState))})
+	return waitErr
 }
 
-func unmountNetworkNamespace(containerId string) {
+func unmountNetworkNamespace(containerId string) error {
 	netNsPath := "/var/run/container/net-ns" + "/" + containerId
-	if err := unix.Unmount(netNsPath, 0); err != nil {
-		log.Fatalf("Unable to unmount network namespace: %v at %s \n", err, netNsPath)
+	if err := unix.Unmount(netNsPath, 0); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return fmt.Errorf("unable to unmount network namespace at %s: %v", netNsPath, err)
+	}
+	if err := os.Remove(netNsPath); err != nil && !os.IsNotExist(err) {
+		return err
 	}
+	return nil
 }
 
 // unmountContainerFs
-func unmountContainerFs(containerId string) {
+func unmountContainerFs(containerId string) error {
 	mountedPath := "/var/run/container/containers/" + containerId + "/fs/mnt"
-	if err := unix.Unmount(mountedPath, 0); err != nil {
-		log.Fatalf("Unable to unmount container fs: %v at %s \n", err, mountedPath)
+	if err := unix.Unmount(mountedPath, 0); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return fmt.Errorf("unable to unmount container fs at %s: %v", mountedPath, err)
 	}
+	return nil
 }
 
 func copyNameServerConfig(containerId string) error {
@@ -188,18 +240,16 @@ func copyNameServerConfig(containerId string) error {
 	return nil
 }
 
-func ExecContainerCommand(memory int, swap int, pids int, cpus float64, containerId string, imageHash string, args []string) {
+func ExecContainerCommand(containerId string) {
+	state, err := LoadState(containerId)
+	utils.DoOrDieWithMessage(err, "Unable to load container state")
+
 	mountedPath := "/var/run/container/containers/" + containerId + "/fs/mnt"
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
 
-	imgConfig := image.ParseContainerConfig(imageHash)
+	imgConfig := image.ParseContainerConfig(state.ImageHash)
 	utils.DoOrDieWithMessage(unix.Sethostname([]byte(containerId)), "Unable to set hostname")
 	utils.DoOrDieWithMessage(network.JoinContainerNetworkNamespace(containerId), "Unable to join container network namespace")
-	cgroup.CreateCGroups(containerId, true)
-	cgroup.ConfigureCGroups(containerId, memory, swap, pids, cpus)
+	cgroup.CreateCGroups(containerId, false)
 	utils.DoOrDieWithMessage(copyNameServerConfig(containerId), "Unable to copy resolve.conf")
 
 	//! TODO
@@ -212,16 +262,183 @@ func ExecContainerCommand(memory int, swap int, pids int, cpus float64, containe
 	utils.DoOrDieWithMessage(unix.Mount("tmpfs", "/dev", "tmpfs", 0, ""), "Unable to mount tmpfs on /dev")
 
 	utils.CreateDirsIfDontExist([]string{"/dev/pts"})
-	utils.DoOrDieWithMessage(unix.Mount("devpts", "/dev/pts", "devpts", 0, ""), "Unable to mount devpts")
+	utils.DoOrDieWithMessage(unix.Mount("devpts", "/dev/pts", "devpts", 0, "newinstance,ptmxmode=0666,mode=0620"), "Unable to mount devpts")
 	utils.DoOrDieWithMessage(unix.Mount("sysfs", "/sys", "sysfs", 0, ""), "Unable to mount sysfs")
+	createDevices()
 
 	network.SetupLocalInterface()
 
-	cmd.Env = imgConfig.Config.Env
-	cmd.Run()
-	utils.DoOrDie(unix.Unmount("/dev/pts", 0))
-	utils.DoOrDie(unix.Unmount("/dev", 0))
-	utils.DoOrDie(unix.Unmount("/sys", 0))
-	utils.DoOrDie(unix.Unmount("/proc", 0))
-	utils.DoOrDie(unix.Unmount("/tmp", 0))
+	env := containerEnv(state, imgConfig.Config)
+	// Look the command up on the image's PATH, now that we are inside it.
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			os.Setenv("PATH", strings.TrimPrefix(kv, "PATH="))
+		}
+	}
+	path, err := exec.LookPath(state.Command[0])
+	if err != nil {
+		log.Printf("Unable to run %s: %v\n", state.Command[0], err)
+		os.Exit(127)
+	}
+
+	var console *os.File
+	if state.Tty {
+		console, err = setupConsole()
+		utils.DoOrDieWithMessage(err, "Unable to set up the container terminal")
+	}
+
+	if !state.Init {
+		// The command takes our place as PID 1 of the container.
+		if console != nil {
+			utils.DoOrDieWithMessage(takeConsole(console), "Unable to set up the container terminal")
+		}
+		err := unix.Exec(path, state.Command, env)
+		log.Printf("Unable to run %s: %v\n", state.Command[0], err)
+		os.Exit(127)
+	}
+
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   state.Command,
+		Env:    env,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		// A process group of its own, so that signals can be passed on
+		// to everything the command starts.
+		SysProcAttr: &unix.SysProcAttr{Setpgid: true},
+	}
+	if console != nil {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = console, console, console
+		cmd.SysProcAttr = &unix.SysProcAttr{
+			Setsid:  true,
+			Setctty: true,
+			Ctty:    0,
+		}
+	}
+	status := runInit(cmd)
+
+	for _, mountPoint := range []string{"/dev/pts", "/dev", "/sys", "/proc", "/tmp"} {
+		if err := unix.Unmount(mountPoint, 0); err != nil {
+			log.Printf("Unable to unmount %s: %v\n", mountPoint, err)
+		}
+	}
+	// Hand the command's status up to whoever is waiting for the container.
+	os.Exit(status)
+}
+
+// containerEnv is the environment the container command runs with.
+func containerEnv(state *State, config common.ImageConfigDetails) []string {
+	env := config.Env
+	if state.Tty {
+		env = append(env, "TERM=xterm")
+	}
+	return env
+}
+
+// createDevices fills the container's empty /dev with the few device
+// nodes most programs expect to find there.
+func createDevices() {
+	devices := []struct {
+		path         string
+		major, minor uint32
+	}{
+		{"/dev/null", 1, 3},
+		{"/dev/zero", 1, 5},
+		{"/dev/full", 1, 7},
+		{"/dev/random", 1, 8},
+		{"/dev/urandom", 1, 9},
+		{"/dev/tty", 5, 0},
+	}
+	for _, dev := range devices {
+		if err := unix.Mknod(dev.path, unix.S_IFCHR|0666, int(unix.Mkdev(dev.major, dev.minor))); err != nil {
+			log.Printf("Unable to create %s: %v\n", dev.path, err)
+		}
+	}
+	if err := os.Symlink("pts/ptmx", "/dev/ptmx"); err != nil {
+		log.Printf("Unable to create /dev/ptmx: %v\n", err)
+	}
+}
+
+// setupConsole allocates a pseudo-terminal from the container's own
+// devpts for the command, sends the master side to the supervisor, which
+// waits for it on the console socket passed to us as fd 3, and returns
+// the slave side.
+func setupConsole() (*os.File, error) {
+	consoleSocket := os.NewFile(3, "console-socket")
+	defer consoleSocket.Close()
+
+	master, slave, err := tty.OpenPty("/dev/pts")
+	if err != nil {
+		return nil, err
+	}
+	defer master.Close()
+	if err := tty.SendFd(consoleSocket, master); err != nil {
+		slave.Close()
+		return nil, err
+	}
+	return slave, nil
+}
+
+// takeConsole makes console our controlling terminal and standard
+// streams, for a command that is about to replace us.
+func takeConsole(console *os.File) error {
+	if _, err := unix.Setsid(); err != nil {
+		return err
+	}
+	if err := unix.IoctlSetInt(int(console.Fd()), unix.TIOCSCTTY, 0); err != nil {
+		return err
+	}
+	for fd := 0; fd < 3; fd++ {
+		if err := unix.Dup3(int(console.Fd()), fd, 0); err != nil {
+			return err
+		}
+	}
+	return console.Close()
+}
+
+// ExitStatus follows the shell convention: the exit code of the process,
+// or 128 plus the signal number if a signal killed it.
+func ExitStatus(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		return waitStatusCode(status)
+	}
+	return state.ExitCode()
+}
+
+func waitStatusCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// startError is returned when the container process could not be started
+// at all; restarting it would only fail the same way.
+type startError struct {
+	err error
+}
+
+func (e *startError) Error() string {
+	return "unable to start container: " + e.err.Error()
+}
+
+// recordStartFailure marks the container as exited with the code Docker
+// uses for a command that cannot be run, 127 if it does not exist and 125
+// otherwise, so it does not stay created with an exit code of 0.
+func recordStartFailure(state *State, err error) error {
+	exitCode := 125
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		exitCode = 127
+	}
+	if _, err := UpdateState(state.Id, func(s *State) error {
+		s.Status = StatusExited
+		s.ExitCode = exitCode
+		s.FinishedAt = time.Now()
+		return nil
+	}); err != nil {
+		log.Printf("Unable to record container exit: %v\n", err)
+	}
+	emitEvent(state, "die", map[string]string{"exitCode": strconv.Itoa(exitCode)})
+	return &startError{err}
 }
@@ -0,0 +1,146 @@
+package container
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	The shim is a small supervisor process that owns a detached container.
+	`run -d` prepares the container as usual and then hands it over to
+	`/proc/self/exe shim`, which runs in its own session so that it keeps
+	running after the CLI exits. The shim starts the container process,
+	waits for it and tears the container down once it is gone.
+*/
+
+// startShim launches the supervisor for containerId and returns without
+// waiting for it.
+func startShim(containerId string) error {
+	logFile, err := os.OpenFile(containerHome(containerId)+"/shim.log",
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command("/proc/self/exe", "shim", containerId)
+	cmd.Stdin = devNull
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &unix.SysProcAttr{
+		Setsid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Container supervisor started with pid %d\n", cmd.Process.Pid)
+
+	return cmd.Process.Release()
+}
+
+// RunShim is the body of the supervisor process. It runs the container
+// until it exits for good and then releases its resources.
+func RunShim(containerId string) {
+	log.Printf("Supervising container %s\n", containerId)
+	if _, err := UpdateState(containerId, func(s *State) error {
+		s.ShimPid = os.Getpid()
+		return nil
+	}); err != nil {
+		log.Printf("Unable to record supervisor pid: %v\n", err)
+	}
+
+	// Nobody is watching: the output only goes to the container log.
+	superviseContainer(containerId, &containerIO{})
+	log.Printf("Container %s done.\n", containerId)
+
+	releaseContainer(containerId)
+}
+
+// superviseContainer runs the container process and, as its restart
+// policy says, starts it again with exponential backoff each time it
+// exits. The overlay, network namespace and cgroups are reused across
+// restarts. It returns the exit status of the last run.
+func superviseContainer(containerId string, stdio *containerIO) int {
+	state, err := LoadState(containerId)
+	if err != nil {
+		log.Printf("Unable to load container state: %v\n", err)
+		return -1
+	}
+	if stdio.log, err = newJSONLogWriter(logPath(containerId), state.LogConfig); err != nil {
+		log.Printf("Unable to open container log: %v\n", err)
+	} else {
+		defer stdio.log.Close()
+	}
+
+	// A detached container run with -i reads stdin from whoever attaches.
+	if stdio.Stdin == nil && state.OpenStdin && !state.Tty {
+		r, w, err := os.Pipe()
+		if err != nil {
+			log.Printf("Unable to create container stdin: %v\n", err)
+		} else {
+			defer r.Close()
+			defer w.Close()
+			stdio.Stdin, stdio.stdinPipe = r, w
+		}
+	}
+	if stdio.attach, err = listenAttach(containerId, stdio, state.OpenStdin); err != nil {
+		log.Printf("Unable to listen for attach: %v\n", err)
+	} else {
+		defer stdio.attach.close()
+	}
+
+	var backoff time.Duration
+	for {
+		startedAt := time.Now()
+		runErr := prepareAndExecuteContainer(containerId, stdio)
+		if runErr != nil {
+			log.Printf("Container exited: %v\n", runErr)
+		}
+
+		state, err := LoadState(containerId)
+		if err != nil {
+			return -1
+		}
+		var startErr *startError
+		if errors.As(runErr, &startErr) || !state.shouldRestart() {
+			return state.ExitCode
+		}
+
+		backoff = nextBackoff(backoff, time.Since(startedAt))
+		if _, err := UpdateState(containerId, func(s *State) error {
+			s.Status = StatusRestarting
+			return nil
+		}); err != nil {
+			log.Printf("Unable to record container restart: %v\n", err)
+		}
+		log.Printf("Restarting container %s in %v (exit code %d)\n", containerId, backoff, state.ExitCode)
+		sleepBackoff(containerId, backoff)
+
+		// The user may have stopped the container while we were waiting.
+		state, err = UpdateState(containerId, func(s *State) error {
+			if s.StoppedByUser {
+				s.Status = StatusExited
+			} else {
+				s.RestartCount++
+			}
+			return nil
+		})
+		if err != nil {
+			return -1
+		}
+		if state.StoppedByUser {
+			return state.ExitCode
+		}
+	}
+}
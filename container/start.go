@@ -0,0 +1,121 @@
+package container
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/sunweiwe/container/tty"
+	"golang.org/x/sys/unix"
+)
+
+// StartContainer launches the process of a created or exited container.
+// With attach the container runs in the foreground of this process and
+// its exit status is returned, otherwise it is handed over to a shim and
+// StartContainer returns as soon as the shim is up.
+func StartContainer(containerId string, attach bool) (int, error) {
+	state, err := LoadState(containerId)
+	if err != nil {
+		return 0, fmt.Errorf("no such container: %s", containerId)
+	}
+
+	switch state.Status {
+	case StatusRunning, StatusPaused, StatusRestarting:
+		return 0, fmt.Errorf("container %s is already running", containerId)
+	case StatusExited:
+		// The previous run released the mounts, network and cgroups, or
+		// its supervisor died before it could. Start from a clean slate.
+		teardownContainer(containerId)
+		var undo undoStack
+		if err := setupContainer(state, &undo); err != nil {
+			undo.unwind()
+			return 0, err
+		}
+	}
+
+	// An explicit start begins a new series of restarts.
+	if _, err := UpdateState(containerId, func(s *State) error {
+		s.StoppedByUser = false
+		s.RestartCount = 0
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	if !attach {
+		return 0, startShim(containerId)
+	}
+
+	stdio := &containerIO{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if state.Tty && tty.IsTerminal(os.Stdin.Fd()) {
+		restore, err := attachTerminal(stdio)
+		if err != nil {
+			return 0, err
+		}
+		defer restore()
+	}
+
+	// Whatever happens to the container, it is ours to clean up.
+	defer releaseContainer(containerId)
+	stopForwarding := forwardSignals(containerId, stdio.sharesTerminal(state.Tty))
+	defer stopForwarding()
+
+	// 创建 namespace ，通过ns
+	exitCode := superviseContainer(containerId, stdio)
+	log.Printf("Container done.\n")
+	return exitCode, nil
+}
+
+// forwardSignals passes the signals we get while running a container in
+// the foreground on to its main process, instead of letting them kill us
+// before we have cleaned up. A signal that would have terminated us also
+// keeps the container from being restarted. When the container is in our
+// terminal's foreground process group it gets the keyboard signals from
+// the terminal itself, so those are not sent a second time.
+func forwardSignals(containerId string, sharesTerminal bool) func() {
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, unix.SIGINT, unix.SIGTERM, unix.SIGHUP, unix.SIGQUIT, unix.SIGUSR1, unix.SIGUSR2)
+
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case unix.SIGINT, unix.SIGTERM, unix.SIGHUP, unix.SIGQUIT:
+				if _, err := UpdateState(containerId, func(s *State) error {
+					s.StoppedByUser = true
+					return nil
+				}); err != nil {
+					log.Printf("Unable to record container stop: %v\n", err)
+				}
+			}
+			if sharesTerminal && (sig == unix.SIGINT || sig == unix.SIGQUIT) {
+				continue
+			}
+			pid := GetPidForRunningContainer(containerId)
+			if pid == 0 {
+				continue
+			}
+			if err := unix.Kill(pid, sig.(unix.Signal)); err != nil && err != unix.ESRCH {
+				log.Printf("Unable to forward %v to the container: %v\n", sig, err)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// attachTerminal puts our terminal into raw mode, so keystrokes reach the
+// container's pty untouched, and keeps the pty the same size as our
+// terminal. The returned function undoes both.
+func attachTerminal(stdio *containerIO) (func(), error) {
+	return tty.Relay(os.Stdin.Fd(), func(ws *unix.Winsize) {
+		stdio.Resize(ws)
+	})
+}
@@ -0,0 +1,216 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sunweiwe/container/common"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	containersBasePath = "/var/run/container/containers"
+)
+
+type Status string
+
+const (
+	StatusCreated    Status = "created"
+	StatusRunning    Status = "running"
+	StatusPaused     Status = "paused"
+	StatusRestarting Status = "restarting"
+	StatusExited     Status = "exited"
+)
+
+// Resources are the cgroup limits of a container, as given to create or
+// changed since by update. A negative value means no limit.
+type Resources struct {
+	Memory int     `json:"memory"`
+	Swap   int     `json:"swap"`
+	Pids   int     `json:"pids"`
+	Cpus   float64 `json:"cpus"`
+}
+
+// State is everything we know about a container. It is stored as
+// state.json in the container's directory and outlives the container
+// process, so stopped containers can still be listed and cleaned up.
+type State struct {
+	Id            string        `json:"id"`
+	Name          string        `json:"name,omitempty"`
+	Image         string        `json:"image"`
+	ImageHash     string        `json:"imageHash"`
+	Command       []string      `json:"command"`
+	Resources     Resources     `json:"resources"`
+	AutoRemove    bool          `json:"autoRemove"`
+	Tty           bool          `json:"tty"`
+	OpenStdin     bool          `json:"openStdin"`
+	Init          bool          `json:"init"`
+	RestartPolicy RestartPolicy `json:"restartPolicy"`
+	LogConfig     LogConfig     `json:"logConfig"`
+	// Healthcheck is the image's HEALTHCHECK with the run options applied,
+	// nil if the container has none.
+	Healthcheck *common.HealthConfig `json:"healthcheck,omitempty"`
+
+	Pid          int       `json:"pid"`
+	ShimPid      int       `json:"shimPid,omitempty"`
+	Status       Status    `json:"status"`
+	ExitCode     int       `json:"exitCode"`
+	RestartCount int       `json:"restartCount"`
+	Health       *Health   `json:"health,omitempty"`
+	Created      time.Time `json:"created"`
+	StartedAt    time.Time `json:"startedAt"`
+	FinishedAt   time.Time `json:"finishedAt"`
+	// StoppedByUser is set by stop so that the supervisor does not bring
+	// the container back.
+	StoppedByUser bool `json:"stoppedByUser"`
+}
+
+func containerHome(containerId string) string {
+	return containersBasePath + "/" + containerId
+}
+
+func statePath(containerId string) string {
+	return containerHome(containerId) + "/state.json"
+}
+
+func LoadState(containerId string) (*State, error) {
+	data, err := os.ReadFile(statePath(containerId))
+	if err != nil {
+		return nil, err
+	}
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("unable to parse state of %s: %v", containerId, err)
+	}
+	s.checkAlive()
+	return s, nil
+}
+
+// Save writes the state atomically so that readers never observe a
+// partially written file.
+func (s *State) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := statePath(s.Id) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, statePath(s.Id))
+}
+
+// UpdateState applies update to the stored state of containerId. The
+// state is locked for the duration of the update, since the shim and the
+// CLI may both write to it.
+func UpdateState(containerId string, update func(s *State) error) (*State, error) {
+	lockFile, err := os.OpenFile(containerHome(containerId)+"/state.lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+	if err := unix.Flock(int(lockFile.Fd()), unix.LOCK_EX); err != nil {
+		return nil, err
+	}
+	defer unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+
+	s, err := LoadState(containerId)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(s); err != nil {
+		return nil, err
+	}
+	return s, s.Save()
+}
+
+// ListStates returns the state of every container we know about, newest
+// first.
+func ListStates() ([]*State, error) {
+	var states []*State
+	entries, err := os.ReadDir(containersBasePath)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		s, err := LoadState(entry.Name())
+		if err != nil {
+			continue
+		}
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Created.After(states[j].Created)
+	})
+	return states, nil
+}
+
+// checkAlive handles the case where the process that was supposed to
+// record the exit (the shim or the foreground CLI) died and the state
+// still says running. Such containers are reported as exited instead of
+// trusting a stale PID.
+func (s *State) checkAlive() {
+	switch {
+	case s.IsRunning():
+		if s.Pid > 0 && unix.Kill(s.Pid, 0) != unix.ESRCH {
+			return
+		}
+	case s.Status == StatusRestarting:
+		// Between two runs only the shim is there to vouch for it.
+		if s.ShimPid > 0 && unix.Kill(s.ShimPid, 0) != unix.ESRCH {
+			return
+		}
+	default:
+		return
+	}
+	s.Status = StatusExited
+	s.ExitCode = -1
+}
+
+// IsRunning reports whether the container has a live process. A paused
+// container still does.
+func (s *State) IsRunning() bool {
+	return s.Status == StatusRunning || s.Status == StatusPaused
+}
+
+// StatusString describes the state the way `ps` shows it.
+func (s *State) StatusString() string {
+	switch s.Status {
+	case StatusRunning:
+		if s.Health != nil {
+			return "Up " + humanDuration(time.Since(s.StartedAt)) + " (" + s.Health.Status + ")"
+		}
+		return "Up " + humanDuration(time.Since(s.StartedAt))
+	case StatusPaused:
+		return "Up " + humanDuration(time.Since(s.StartedAt)) + " (Paused)"
+	case StatusRestarting:
+		return fmt.Sprintf("Restarting (%d) %s ago", s.ExitCode, humanDuration(time.Since(s.FinishedAt)))
+	case StatusExited:
+		return fmt.Sprintf("Exited (%d) %s ago", s.ExitCode, humanDuration(time.Since(s.FinishedAt)))
+	default:
+		return "Created"
+	}
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,207 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sunweiwe/container/cgroup"
+	"github.com/sunweiwe/container/network"
+)
+
+const statsInterval = time.Second
+
+// ContainerStats is one line of `stats` output. CPU and block I/O are
+// nil, and left out of JSON, where the host does not account them.
+type ContainerStats struct {
+	Id            string   `json:"id"`
+	Name          string   `json:"name"`
+	CPUPercent    *float64 `json:"cpuPercent,omitempty"`
+	MemoryUsage   uint64   `json:"memoryUsage"`
+	MemoryLimit   uint64   `json:"memoryLimit"`
+	MemoryPercent float64  `json:"memoryPercent"`
+	Pids          uint64   `json:"pids"`
+	PidsLimit     uint64   `json:"pidsLimit,omitempty"`
+	NetRx         uint64   `json:"netRx"`
+	NetTx         uint64   `json:"netTx"`
+	BlockRead     *uint64  `json:"blockRead,omitempty"`
+	BlockWrite    *uint64  `json:"blockWrite,omitempty"`
+}
+
+// statsSample is a reading of the cumulative counters of a container, from
+// which the CPU use over the time between two samples is worked out.
+type statsSample struct {
+	at    time.Time
+	stats *cgroup.Stats
+}
+
+// PrintStats shows the resource usage of the given containers, or of all
+// running containers if there are none, every second until interrupted.
+// With noStream it shows a single reading. format is "" for a table or
+// "json" for one JSON object per container and reading.
+func PrintStats(containerIds []string, noStream bool, format string) error {
+	if format != "" && format != "json" {
+		return fmt.Errorf("unknown format %q, expected json", format)
+	}
+
+	previous := map[string]statsSample{}
+	// CPU use needs two readings, so the first output waits for a second.
+	takeSamples(containerIds, previous)
+	for {
+		time.Sleep(statsInterval)
+		current := map[string]statsSample{}
+		states := takeSamples(containerIds, current)
+
+		var lines []ContainerStats
+		for _, state := range states {
+			sample, ok := current[state.Id]
+			if !ok {
+				continue
+			}
+			lines = append(lines, containerStats(state, sample, previous[state.Id]))
+		}
+		previous = current
+
+		if format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			for _, line := range lines {
+				encoder.Encode(line)
+			}
+		} else {
+			if !noStream {
+				// Clear the screen and start again at the top, like top.
+				fmt.Print("\033[2J\033[H")
+			}
+			printStatsTable(os.Stdout, lines)
+		}
+		if noStream {
+			return nil
+		}
+	}
+}
+
+// takeSamples reads the counters of the containers into samples and
+// returns their states. Containers that are not running are skipped.
+func takeSamples(containerIds []string, samples map[string]statsSample) []*State {
+	var states []*State
+	if len(containerIds) == 0 {
+		states, _ = GetRunningContainers()
+	} else {
+		for _, containerId := range containerIds {
+			if state, err := LoadState(containerId); err == nil && state.IsRunning() {
+				states = append(states, state)
+			}
+		}
+	}
+
+	for _, state := range states {
+		stats, err := cgroup.GetStats(state.Id)
+		if err != nil {
+			continue
+		}
+		samples[state.Id] = statsSample{at: time.Now(), stats: stats}
+	}
+	return states
+}
+
+func containerStats(state *State, sample statsSample, previous statsSample) ContainerStats {
+	stats := ContainerStats{
+		Id:          state.Id,
+		Name:        state.Name,
+		MemoryUsage: sample.stats.MemoryUsage,
+		MemoryLimit: sample.stats.MemoryLimit,
+		Pids:        sample.stats.Pids,
+		PidsLimit:   sample.stats.PidsLimit,
+	}
+	if sample.stats.HasBlockIO {
+		stats.BlockRead, stats.BlockWrite = &sample.stats.BlockRead, &sample.stats.BlockWrite
+	}
+
+	// 100% is one CPU kept busy for the whole interval.
+	if sample.stats.HasCPU {
+		cpuPercent := 0.0
+		if previous.stats != nil && sample.stats.CPUUsage >= previous.stats.CPUUsage {
+			if elapsed := sample.at.Sub(previous.at); elapsed > 0 {
+				cpuPercent = float64(sample.stats.CPUUsage-previous.stats.CPUUsage) / float64(elapsed) * 100
+			}
+		}
+		stats.CPUPercent = &cpuPercent
+	}
+
+	// Without a limit of its own the container can use all of the host's
+	// memory.
+	if stats.MemoryLimit == 0 {
+		stats.MemoryLimit = hostMemory()
+	}
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	stats.NetRx, stats.NetTx, _ = network.GetVirtualEthStats(state.Id)
+	return stats
+}
+
+func printStatsTable(out io.Writer, lines []ContainerStats) {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS")
+	for _, s := range lines {
+		pids := strconv.FormatUint(s.Pids, 10)
+		if s.PidsLimit > 0 {
+			pids += " / " + strconv.FormatUint(s.PidsLimit, 10)
+		}
+		// Like docker stats, "--" for what the host does not account.
+		cpu, blockIO := "--", "--"
+		if s.CPUPercent != nil {
+			cpu = strconv.FormatFloat(*s.CPUPercent, 'f', 2, 64) + "%"
+		}
+		if s.BlockRead != nil && s.BlockWrite != nil {
+			blockIO = decimalSize(*s.BlockRead) + " / " + decimalSize(*s.BlockWrite)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s / %s\t%.2f%%\t%s / %s\t%s\t%s\n",
+			s.Id, s.Name, cpu,
+			binarySize(s.MemoryUsage), binarySize(s.MemoryLimit), s.MemoryPercent,
+			decimalSize(s.NetRx), decimalSize(s.NetTx), blockIO, pids)
+	}
+	w.Flush()
+}
+
+// hostMemory returns MemTotal from /proc/meminfo in bytes, or zero if it
+// cannot be read.
+func hostMemory() uint64 {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// binarySize and decimalSize print sizes the way docker stats does:
+// memory in powers of 1024, traffic in powers of 1000.
+func binarySize(n uint64) string {
+	return humanSize(float64(n), 1024, []string{"B", "KiB", "MiB", "GiB", "TiB"})
+}
+
+func decimalSize(n uint64) string {
+	return humanSize(float64(n), 1000, []string{"B", "kB", "MB", "GB", "TB"})
+}
+
+func humanSize(n float64, base float64, units []string) string {
+	i := 0
+	for n >= base && i < len(units)-1 {
+		n /= base
+		i++
+	}
+	return strconv.FormatFloat(n, 'g', 4, 64) + units[i]
+}
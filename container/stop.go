@@ -0,0 +1,118 @@
+package container
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/sunweiwe/container/cgroup"
+	"github.com/sunweiwe/container/image"
+	"github.com/sunweiwe/container/utils"
+	"golang.org/x/sys/unix"
+)
+
+// StopContainer sends the image's stop signal (SIGTERM unless the image
+// says otherwise) to the container's main process. If it has not exited
+// after timeout, every process left in the container's cgroup is killed.
+func StopContainer(containerId string, timeout time.Duration) error {
+	state, err := UpdateState(containerId, func(s *State) error {
+		if s.Status != StatusExited {
+			s.StoppedByUser = true
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("no such container: %s", containerId)
+	}
+	// Already stopped, there is nothing to do.
+	if state.Status == StatusExited {
+		return nil
+	}
+	// Waiting to be restarted: the supervisor sees the flag and gives up.
+	if state.Status == StatusRestarting {
+		return nil
+	}
+
+	pid := GetPidForRunningContainer(containerId)
+	if pid == 0 {
+		return fmt.Errorf("no such running container: %s", containerId)
+	}
+
+	stopSignal := unix.SIGTERM
+	if sig := image.ParseContainerConfig(state.ImageHash).Config.StopSignal; len(sig) > 0 {
+		if stopSignal, err = utils.ParseSignal(sig); err != nil {
+			return err
+		}
+	}
+
+	if err := unix.Kill(pid, stopSignal); err != nil && err != unix.ESRCH {
+		return err
+	}
+	// Frozen processes only act on their signals once they are thawed.
+	if state.Status == StatusPaused {
+		if err := UnpauseContainer(containerId); err != nil {
+			return err
+		}
+	}
+	if !waitForExit(pid, timeout) {
+		log.Printf("Container %s did not exit within %v, killing it\n", containerId, timeout)
+		killAllProcesses(containerId, pid)
+		if !waitForExit(pid, 10*time.Second) {
+			return fmt.Errorf("container %s is still running after SIGKILL", containerId)
+		}
+	}
+	emitEvent(state, "stop", nil)
+	return nil
+}
+
+// KillContainer sends sig to the container's main process. A SIGKILL
+// counts as the user stopping the container, so it is not restarted.
+func KillContainer(containerId string, sig unix.Signal) error {
+	pid := GetPidForRunningContainer(containerId)
+	if pid == 0 {
+		return fmt.Errorf("no such running container: %s", containerId)
+	}
+	state, err := UpdateState(containerId, func(s *State) error {
+		if sig == unix.SIGKILL {
+			s.StoppedByUser = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := unix.Kill(pid, sig); err != nil {
+		return err
+	}
+	emitEvent(state, "kill", map[string]string{"signal": strconv.Itoa(int(sig))})
+	return nil
+}
+
+func killAllProcesses(containerId string, mainPid int) {
+	pids, err := cgroup.GetCGroupPids(containerId)
+	if err != nil {
+		log.Printf("Unable to read container processes: %v\n", err)
+		pids = []int{mainPid}
+	}
+	for _, pid := range pids {
+		if err := unix.Kill(pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+			log.Printf("Unable to kill process %d: %v\n", pid, err)
+		}
+	}
+}
+
+// waitForExit polls until pid has gone away or timeout expires, and
+// reports whether the process exited.
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := unix.Kill(pid, 0); err == unix.ESRCH {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,166 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sunweiwe/container/cgroup"
+)
+
+// The kernel reports CPU time in clock ticks, which are 1/100 s on every
+// architecture we run on.
+const clockTicksPerSecond = 100
+
+// processInfo is what top shows about one process in a container.
+type processInfo struct {
+	Pid     int
+	NsPid   int
+	User    string
+	CPUTime time.Duration
+	RSS     int64
+	Command string
+}
+
+// topField is one column top can show, ps style.
+type topField struct {
+	header string
+	value  func(p *processInfo) string
+}
+
+var topFields = map[string]topField{
+	"pid":   {"PID", func(p *processInfo) string { return strconv.Itoa(p.Pid) }},
+	"nspid": {"NSPID", func(p *processInfo) string { return strconv.Itoa(p.NsPid) }},
+	"user":  {"USER", func(p *processInfo) string { return p.User }},
+	"time":  {"TIME", func(p *processInfo) string { return formatCPUTime(p.CPUTime) }},
+	"rss":   {"RSS", func(p *processInfo) string { return strconv.FormatInt(p.RSS/1024, 10) }},
+	"cmd":   {"CMD", func(p *processInfo) string { return p.Command }},
+}
+
+var defaultTopFields = []string{"pid", "nspid", "user", "time", "rss", "cmd"}
+
+// TopContainer lists every process in the container's cgroup. fields
+// picks the columns, from pid, nspid, user, time, rss (in KiB) and cmd;
+// they may also be given comma separated, as to ps -o.
+func TopContainer(containerId string, fields []string) error {
+	if GetPidForRunningContainer(containerId) == 0 {
+		return fmt.Errorf("container %s is not running", containerId)
+	}
+
+	var columns []topField
+	for _, arg := range fields {
+		for _, name := range strings.Split(arg, ",") {
+			field, ok := topFields[strings.ToLower(name)]
+			if !ok {
+				return fmt.Errorf("unknown field %q, expected one of %s", name, strings.Join(defaultTopFields, ", "))
+			}
+			columns = append(columns, field)
+		}
+	}
+	if len(columns) == 0 {
+		for _, name := range defaultTopFields {
+			columns = append(columns, topFields[name])
+		}
+	}
+
+	pids, err := cgroup.GetCGroupPids(containerId)
+	if err != nil {
+		return fmt.Errorf("unable to read container processes: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.header
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, pid := range pids {
+		process, err := readProcessInfo(pid)
+		if err != nil {
+			// Gone between reading cgroup.procs and /proc.
+			continue
+		}
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = column.value(process)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	return w.Flush()
+}
+
+func readProcessInfo(pid int) (*processInfo, error) {
+	procPath := "/proc/" + strconv.Itoa(pid)
+	process := &processInfo{Pid: pid, NsPid: pid}
+
+	status, err := os.ReadFile(procPath + "/status")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "Uid":
+			process.User = userName(fields[0])
+		case "NSpid":
+			// One PID per namespace level, the container's is the last.
+			process.NsPid, _ = strconv.Atoi(fields[len(fields)-1])
+		case "VmRSS":
+			kb, _ := strconv.ParseInt(fields[0], 10, 64)
+			process.RSS = kb * 1024
+		}
+	}
+
+	stat, err := os.ReadFile(procPath + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	// The command name in parentheses may itself contain spaces, so the
+	// fields are counted from the closing parenthesis. utime and stime
+	// are fields 14 and 15 of proc(5).
+	statFields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(statFields) > 12 {
+		utime, _ := strconv.ParseInt(statFields[11], 10, 64)
+		stime, _ := strconv.ParseInt(statFields[12], 10, 64)
+		process.CPUTime = time.Duration(utime+stime) * time.Second / clockTicksPerSecond
+	}
+
+	cmdline, err := os.ReadFile(procPath + "/cmdline")
+	if err != nil {
+		return nil, err
+	}
+	process.Command = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	if len(process.Command) == 0 {
+		// Zombies have no command line left, show their name like ps.
+		comm, _ := os.ReadFile(procPath + "/comm")
+		process.Command = "[" + strings.TrimSpace(string(comm)) + "]"
+	}
+	return process, nil
+}
+
+// userName looks the uid up in the host's user database, which is what ps
+// on the host would show too.
+func userName(uid string) string {
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return uid
+}
+
+// formatCPUTime prints d as hh:mm:ss, like the TIME column of ps.
+func formatCPUTime(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	hours := seconds / 3600
+	return fmt.Sprintf("%02d:%02d:%02d", hours, seconds/60%60, seconds%60)
+}
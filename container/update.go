@@ -0,0 +1,93 @@
+package container
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/sunweiwe/container/cgroup"
+)
+
+// Below this the container's processes hardly get started, so like Docker
+// we refuse smaller memory limits.
+const minMemory = 6
+
+// UpdateContainer changes the resource limits of a container. Limits left
+// negative in update are kept as they are. The new limits are written to
+// the cgroup of a container that has one and saved to its state, so the
+// container is started with them from now on.
+func UpdateContainer(containerId string, update Resources) error {
+	state, err := UpdateState(containerId, func(s *State) error {
+		resources, err := mergeResources(s.Resources, update)
+		if err != nil {
+			return err
+		}
+
+		// An exited container has no cgroup; it gets one with the new
+		// limits when it is started again.
+		if s.Status != StatusExited {
+			warnMemoryBelowUsage(containerId, update.Memory)
+			if err := cgroup.ConfigureCGroups(containerId, resources.Memory, resources.Swap,
+				resources.Pids, resources.Cpus); err != nil {
+				return err
+			}
+		}
+		s.Resources = resources
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	emitEvent(state, "update", nil)
+	return nil
+}
+
+// mergeResources lays the limits given in update over the current ones
+// and checks the result.
+func mergeResources(current Resources, update Resources) (Resources, error) {
+	resources := current
+	if update.Memory >= 0 {
+		if update.Memory < minMemory {
+			return resources, fmt.Errorf("minimum memory limit allowed is %dMB", minMemory)
+		}
+		resources.Memory = update.Memory
+	}
+	if update.Swap >= 0 {
+		resources.Swap = update.Swap
+	}
+	if update.Pids >= 0 {
+		if update.Pids == 0 {
+			return resources, fmt.Errorf("pids limit must be at least 1")
+		}
+		resources.Pids = update.Pids
+	}
+	if update.Cpus >= 0 {
+		if update.Cpus == 0 || update.Cpus > float64(runtime.NumCPU()) {
+			return resources, fmt.Errorf("cpus must be between 0 and %d", runtime.NumCPU())
+		}
+		resources.Cpus = update.Cpus
+	}
+
+	// The swap limit is set on top of the memory limit.
+	if resources.Swap >= 0 && resources.Memory <= 0 {
+		return resources, fmt.Errorf("a swap limit needs a memory limit as well")
+	}
+	return resources, nil
+}
+
+// warnMemoryBelowUsage warns when a new memory limit is below what the
+// container uses right now. The kernel then reclaims what it can and
+// refuses the limit if that is not enough.
+func warnMemoryBelowUsage(containerId string, memory int) {
+	if memory < 0 {
+		return
+	}
+	stats, err := cgroup.GetStats(containerId)
+	if err != nil {
+		return
+	}
+	if limit := uint64(memory) * 1024 * 1024; stats.MemoryUsage > limit {
+		log.Printf("Warning: memory limit of %dMB is below the %s container %s is using\n",
+			memory, binarySize(stats.MemoryUsage), containerId)
+	}
+}
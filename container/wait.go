@@ -0,0 +1,54 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sunweiwe/container/events"
+)
+
+// WaitContainer blocks until the container has exited for good, that is
+// without a restart pending, and returns its exit status.
+func WaitContainer(containerId string) (int, error) {
+	return waitContainer(containerId, false)
+}
+
+// waitContainer is WaitContainer for a container that may already have
+// been seen. One run with --rm is gone as soon as it exits; its exit
+// status is then taken from the die event it left in the journal.
+func waitContainer(containerId string, seen bool) (int, error) {
+	for {
+		state, err := LoadState(containerId)
+		if os.IsNotExist(err) {
+			if seen {
+				return removedExitCode(containerId)
+			}
+			return -1, fmt.Errorf("no such container: %s", containerId)
+		}
+		if err != nil {
+			return -1, err
+		}
+		if state.Status == StatusExited {
+			return state.ExitCode, nil
+		}
+		seen = true
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func removedExitCode(containerId string) (int, error) {
+	e, err := events.Last(events.TypeContainer, "die", containerId)
+	if err != nil {
+		return -1, err
+	}
+	if e == nil {
+		return -1, fmt.Errorf("container %s was removed before it exited", containerId)
+	}
+	exitCode, err := strconv.Atoi(e.Attributes["exitCode"])
+	if err != nil {
+		return -1, fmt.Errorf("invalid exit code recorded for container %s", containerId)
+	}
+	return exitCode, nil
+}
@@ -0,0 +1,238 @@
+//Package events container and image lifecycle journal
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const journalPath = "/var/lib/container/events.jsonl"
+
+const (
+	TypeContainer = "container"
+	TypeImage     = "image"
+	TypeNetwork   = "network"
+)
+
+// Event is one line of the journal.
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	Id         string            `json:"id"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Emit appends an event to the journal. Losing an event is not worth
+// failing the operation it describes, so errors are only logged.
+func Emit(eventType string, action string, id string, attributes map[string]string) {
+	data, err := json.Marshal(Event{
+		Time:       time.Now().UTC(),
+		Type:       eventType,
+		Action:     action,
+		Id:         id,
+		Attributes: attributes,
+	})
+	if err != nil {
+		log.Printf("Unable to encode event: %v\n", err)
+		return
+	}
+	data = append(data, '\n')
+
+	file, err := os.OpenFile(journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Unable to open event journal: %v\n", err)
+		return
+	}
+	defer file.Close()
+	// Several processes write to the journal; keep their lines whole.
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+		log.Printf("Unable to lock event journal: %v\n", err)
+		return
+	}
+	if _, err := file.Write(data); err != nil {
+		log.Printf("Unable to write event: %v\n", err)
+	}
+}
+
+// Filter keeps the events whose attributes match. Values given for the
+// same key are alternatives, different keys must all match. Known keys
+// are type, id, action (or event), name and image; id also matches a
+// prefix of the id.
+type Filter map[string][]string
+
+// ParseFilters reads `--filter key=value` options. An option may hold
+// several comma separated pairs, as in type=container,id=abc.
+func ParseFilters(opts []string) (Filter, error) {
+	filter := Filter{}
+	var pairs []string
+	for _, opt := range opts {
+		pairs = append(pairs, strings.Split(opt, ",")...)
+	}
+	for _, opt := range pairs {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid filter %q, expected key=value", opt)
+		}
+		switch key {
+		case "event":
+			key = "action"
+		case "type", "id", "action", "name", "image", "container":
+		default:
+			return nil, fmt.Errorf("unknown filter: %s", key)
+		}
+		// container=x is short for id=x.
+		if key == "container" {
+			key = "id"
+		}
+		filter[key] = append(filter[key], value)
+	}
+	return filter, nil
+}
+
+func (f Filter) match(e *Event) bool {
+	for key, values := range f {
+		matched := false
+		for _, value := range values {
+			switch key {
+			case "type":
+				matched = e.Type == value
+			case "action":
+				matched = e.Action == value
+			case "id":
+				matched = strings.HasPrefix(e.Id, value) || e.Attributes["name"] == value
+			default:
+				matched = e.Attributes[key] == value
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Options select what Print shows. Without Since or Until only new
+// events are shown, for as long as Print runs. With either, the journal
+// is replayed and Print returns at its end, unless Follow is set; then it
+// keeps going until Until, or for good if it is not set.
+type Options struct {
+	Since  time.Time
+	Until  time.Time
+	Follow bool
+	Filter Filter
+	JSON   bool
+}
+
+// Print writes the events in the journal that match opts to out.
+func Print(out io.Writer, opts Options) error {
+	file, err := os.OpenFile(journalPath, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	follow := opts.Follow
+	if opts.Since.IsZero() && opts.Until.IsZero() {
+		// Replaying the past would have a monitor act on old events again.
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		follow = true
+	}
+
+	reader := bufio.NewReader(file)
+	var pending string
+	for {
+		line, err := reader.ReadString('\n')
+		pending += line
+		if err == io.EOF {
+			if !follow || (!opts.Until.IsZero() && !time.Now().Before(opts.Until)) {
+				return nil
+			}
+			time.Sleep(250 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, pending = pending, ""
+
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+		if !opts.Since.IsZero() && e.Time.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && e.Time.After(opts.Until) {
+			return nil
+		}
+		if !opts.Filter.match(&e) {
+			continue
+		}
+		if opts.JSON {
+			fmt.Fprint(out, line)
+		} else {
+			fmt.Fprintln(out, formatEvent(&e))
+		}
+	}
+}
+
+// Last returns the most recent event in the journal of the given type
+// and action about id, or nil if there is none.
+func Last(eventType string, action string, id string) (*Event, error) {
+	file, err := os.Open(journalPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var last *Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Type == eventType && e.Action == action && e.Id == id {
+			last = &e
+		}
+	}
+	return last, scanner.Err()
+}
+
+// formatEvent prints an event the way docker events does:
+// time type action id (key=value, ...).
+func formatEvent(e *Event) string {
+	s := e.Time.Local().Format(time.RFC3339Nano) + " " + e.Type + " " + e.Action + " " + e.Id
+	if len(e.Attributes) == 0 {
+		return s
+	}
+	keys := make([]string, 0, len(e.Attributes))
+	for key := range e.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]string, len(keys))
+	for i, key := range keys {
+		attrs[i] = key + "=" + e.Attributes[key]
+	}
+	return s + " (" + strings.Join(attrs, ", ") + ")"
+}
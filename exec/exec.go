@@ -2,62 +2,173 @@
 package exec
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 
 	"github.com/sunweiwe/container/cgroup"
 	"github.com/sunweiwe/container/container"
 	"github.com/sunweiwe/container/image"
+	"github.com/sunweiwe/container/tty"
 	"github.com/sunweiwe/container/utils"
 	"golang.org/x/sys/unix"
 )
 
-func ExecContainer(containerId string) {
+// ExecContainer runs command inside a running container and returns its
+// exit status. Our stdin is passed to the command only with interactive.
+// With useTty the command gets a pseudo-terminal from the container's own
+// devpts and, when interactive, our terminal is put into raw mode while it
+// runs.
+func ExecContainer(containerId string, command []string, interactive bool, useTty bool) int {
 	pid := container.GetPidForRunningContainer(containerId)
 	if pid == 0 {
 		log.Fatalf("No such container!")
 	}
 
-	baseNsPath := "/proc/" + strconv.Itoa(pid) + "/ns"
-	ipcFd, ipcErr := os.Open(baseNsPath + "/ipc")
-	mountFd, mountErr := os.Open(baseNsPath + "/mnt")
-	netFd, netErr := os.Open(baseNsPath + "/net")
-	pidFd, pidErr := os.Open(baseNsPath + "pid")
-	utsFd, utsErr := os.Open(baseNsPath + "uts")
+	containerState, err := container.LoadState(containerId)
+	if err != nil {
+		log.Fatalf("Unable to get container configuration")
+	}
+	imageConfig := image.ParseContainerConfig(containerState.ImageHash)
 
-	if ipcErr != nil || mountErr != nil || netErr != nil ||
-		pidErr != nil || utsErr != nil {
-		log.Fatalf("Unable to open namespace files!")
+	// The mount namespace cannot be joined by a multi-threaded process, so
+	// the command is chrooted into the container's root through /proc
+	// instead, which lands it in the container's mounts all the same.
+	rootPath := "/proc/" + strconv.Itoa(pid) + "/root"
+	path, err := lookPath(rootPath, command[0], imageConfig.Config.Env)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	unix.Setns(int(ipcFd.Fd()), unix.CLONE_NEWIPC)
-	unix.Setns(int(mountFd.Fd()), unix.CLONE_NEWNS)
-	unix.Setns(int(netFd.Fd()), unix.CLONE_NEWNET)
-	unix.Setns(int(pidFd.Fd()), unix.CLONE_NEWPID)
-	unix.Setns(int(utsFd.Fd()), unix.CLONE_NEWUTS)
+	// Namespaces are joined by the thread, and the command is forked from
+	// it, so keep this goroutine on one thread from here on.
+	runtime.LockOSThread()
+	baseNsPath := "/proc/" + strconv.Itoa(pid) + "/ns/"
+	namespaces := []struct {
+		name string
+		flag int
+	}{
+		{"ipc", unix.CLONE_NEWIPC},
+		{"net", unix.CLONE_NEWNET},
+		{"pid", unix.CLONE_NEWPID},
+		{"uts", unix.CLONE_NEWUTS},
+	}
+	for _, ns := range namespaces {
+		fd, err := os.Open(baseNsPath + ns.name)
+		if err != nil {
+			log.Fatalf("Unable to open namespace files: %v\n", err)
+		}
+		utils.DoOrDieWithMessage(unix.Setns(int(fd.Fd()), ns.flag), "Unable to join "+ns.name+" namespace")
+		fd.Close()
+	}
+	cgroup.CreateCGroups(containerId, false)
+
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   command,
+		Dir:    "/",
+		Env:    imageConfig.Config.Env,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		SysProcAttr: &unix.SysProcAttr{
+			Chroot: rootPath,
+		},
+	}
+	if interactive {
+		cmd.Stdin = os.Stdin
+	}
+
+	var restore func()
+	if useTty {
+		restore = attachTty(cmd, rootPath, interactive)
+	}
+	err = cmd.Run()
+	if restore != nil {
+		restore()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return container.ExitStatus(exitErr.ProcessState)
+	}
+	utils.DoOrDieWithMessage(err, "Unable to exec command in container")
+	return 0
+}
 
-	containerConfig, err := container.GetRunningContainerInfoForId(containerId)
+// attachTty gives cmd a new pty from the container's devpts as its
+// controlling terminal and relays our terminal to it, keystrokes only if
+// interactive. The returned function waits for the output to drain and
+// restores our terminal.
+func attachTty(cmd *exec.Cmd, rootPath string, interactive bool) func() {
+	master, slave, err := tty.OpenPty(rootPath + "/dev/pts")
 	if err != nil {
-		log.Fatalf("Unable to get container configuration")
+		log.Fatalf("Unable to allocate a terminal: %v\n", err)
 	}
-	imageNameAndTag := strings.Split(containerConfig.Image, ":")
-	exists, imageHash := image.ImageExistByTag(imageNameAndTag[0], imageNameAndTag[1])
-	if !exists {
-		log.Fatalf("Unable to get image details")
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.Env = append(cmd.Env, "TERM=xterm")
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+
+	restoreTerminal := func() {}
+	if !interactive {
+		// Without input there is nothing to put into raw mode for, and
+		// Ctrl-C should still stop us.
+		if ws, err := tty.GetWinsize(os.Stdin.Fd()); err == nil {
+			tty.SetWinsize(master.Fd(), ws)
+		}
+	} else if tty.IsTerminal(os.Stdin.Fd()) {
+		if restoreTerminal, err = tty.Relay(os.Stdin.Fd(), func(ws *unix.Winsize) {
+			tty.SetWinsize(master.Fd(), ws)
+		}); err != nil {
+			log.Fatalf("%v\n", err)
+		}
 	}
 
-	imageConfig := image.ParseContainerConfig(imageHash)
-	containerMountPath := "/var/run/container/containers/" + containerId + "/fs/mnt"
-	cgroup.CreateCGroups(containerId, false)
-	utils.DoOrDieWithMessage(unix.Chroot(containerMountPath), "Unable to chroot!")
-	os.Chdir("/")
-	cmd := exec.Command(os.Args[3], os.Args[4:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Env = imageConfig.Config.Env
-	utils.DoOrDieWithMessage(cmd.Run(), "Unable to exec command in container")
+	if interactive {
+		go io.Copy(master, os.Stdin)
+	}
+	outputDone := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, master)
+		close(outputDone)
+	}()
+
+	return func() {
+		// Our copy of the slave keeps the console open; once it is closed
+		// reading the master ends when the command's side is gone too.
+		slave.Close()
+		<-outputDone
+		master.Close()
+		restoreTerminal()
+	}
+}
+
+// lookPath finds file the way a shell in the container would, on the
+// PATH from the image's environment, but under the container's root.
+func lookPath(rootPath string, file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	searchPath := "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			searchPath = strings.TrimPrefix(kv, "PATH=")
+		}
+	}
+	for _, dir := range filepath.SplitList(searchPath) {
+		path := filepath.Join(dir, file)
+		// Links in the image point into the container's root, not ours,
+		// so finding the entry is as far as we can check from here.
+		if _, err := os.Lstat(rootPath + path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("executable file not found in container: %s", file)
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/crane"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/sunweiwe/container/common"
+	"github.com/sunweiwe/container/events"
 	"github.com/sunweiwe/container/tar"
 	"github.com/sunweiwe/container/utils"
 )
@@ -19,7 +20,7 @@ type imageEntries map[string]string
 type imagesCache map[string]imageEntries
 
 func DownloadImageIfRequired(src string) string {
-	imageName, tag := getImageNameAndTag(src)
+	imageName, tag := GetImageNameAndTag(src)
 	if downloadRequired, imageHash := ImageExistByTag(imageName, tag); !downloadRequired {
 		/* Setup the imageExistByTag we want to pull */
 		log.Printf("Downloading metadata for %s:%s, please wait...", imageName, tag)
@@ -38,15 +39,22 @@ func DownloadImageIfRequired(src string) string {
 		if len(alterImageName) > 0 && len(alterImageTag) > 0 {
 			log.Printf("The image you request %s:%s is the same as %s:%s\n", imageName, tag, alterImageName, alterImageTag)
 			storeImageMetadata(imageName, tag, imageHash)
+			emitPullEvent(imageName, tag, imageHash)
 			return imageHash
 		} else {
 			log.Printf("Image doesn't exist. Downloading...")
+			// Keeps system prune away from the download while it runs.
+			tmpDir := "/var/lib/container/tmp/" + imageHash
+			lock, err := utils.MkdirLocked(tmpDir, 0755)
+			utils.DoOrDieWithMessage(err, "Unable to create temporary image directory")
+			defer lock.Close()
 			downloadImage(img, imageHash, src)
 			untarFile(imageHash)
 			processLayerTarballs(imageHash, manifest.Config.Digest.Hex)
 			// 保存image信息
 			storeImageMetadata(imageName, tag, imageHash)
 			clearTemporaryImageFile(imageHash)
+			emitPullEvent(imageName, tag, imageHash)
 			return imageHash
 		}
 
@@ -57,7 +65,11 @@ func DownloadImageIfRequired(src string) string {
 
 }
 
-func getImageNameAndTag(imageName string) (string, string) {
+func emitPullEvent(imageName string, tag string, imageHash string) {
+	events.Emit(events.TypeImage, "pull", imageHash, map[string]string{"name": imageName + ":" + tag})
+}
+
+func GetImageNameAndTag(imageName string) (string, string) {
 	s := strings.Split(imageName, ":")
 	var img, tag string
 	if len(s) > 1 {
@@ -210,17 +222,27 @@ func clearTemporaryImageFile(imageHash string) {
 }
 
 func ParseContainerConfig(imageHash string) common.ImageConfig {
+	imgConfig, err := ReadContainerConfig(imageHash)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return imgConfig
+}
+
+// ReadContainerConfig is ParseContainerConfig returning an error instead
+// of exiting.
+func ReadContainerConfig(imageHash string) (common.ImageConfig, error) {
 	imagesConfigPath := "/var/lib/container/images/" + imageHash + "/" + imageHash
 	data, err := os.ReadFile(imagesConfigPath)
 	if err != nil {
-		log.Fatalf("Could not read image config file")
+		return common.ImageConfig{}, fmt.Errorf("could not read image config file: %v", err)
 	}
 	imgConfig := common.ImageConfig{}
 	if err := json.Unmarshal(data, &imgConfig); err != nil {
-		log.Fatalf("Unable to parse image config data!")
+		return common.ImageConfig{}, fmt.Errorf("unable to parse image config data: %v", err)
 	}
 
-	return imgConfig
+	return imgConfig, nil
 }
 
 func GetImageAndTagForHash(imageHash string) (string, string) {
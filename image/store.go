@@ -0,0 +1,265 @@
+package image
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sunweiwe/container/common"
+	"github.com/sunweiwe/container/events"
+	"github.com/sunweiwe/container/tar"
+	"github.com/sunweiwe/container/utils"
+)
+
+const (
+	imagesBasePath = "/var/lib/container/images"
+	imagesTmpPath  = "/var/lib/container/tmp"
+)
+
+// CreateImage adds an image to the local store, laid out like a pulled
+// one, made of the layers of the image baseHash, if there is one, and a
+// new top layer. layerTar is the new layer as an uncompressed tar archive
+// and is moved into the store; fillLayer fills the directory containers
+// mount the layer from. changes are applied to the image config as by
+// ApplyChanges and createdBy is recorded in its history. It returns the
+// hash of the new image.
+func CreateImage(imageName string, tag string, baseHash string, layerTar string,
+	fillLayer func(dir string) error, changes []string, createdBy string) (string, error) {
+	diffId, err := fileDigest(layerTar)
+	if err != nil {
+		return "", err
+	}
+
+	config := map[string]interface{}{
+		"architecture": runtime.GOARCH,
+		"os":           "linux",
+		"config":       map[string]interface{}{},
+	}
+	var baseLayers []string
+	if len(baseHash) > 0 {
+		data, err := os.ReadFile(imagesBasePath + "/" + baseHash + "/" + baseHash)
+		if err != nil {
+			return "", fmt.Errorf("unable to read config of image %s: %v", baseHash, err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return "", fmt.Errorf("unable to parse config of image %s: %v", baseHash, err)
+		}
+		mani := common.Manifest{}
+		if err := utils.ParseManifest(imagesBasePath+"/"+baseHash+"/"+baseHash+".json", &mani); err != nil || len(mani) != 1 {
+			return "", fmt.Errorf("unable to read manifest of image %s", baseHash)
+		}
+		baseLayers = mani[0].Layers
+	}
+	if err := ApplyChanges(config, changes); err != nil {
+		return "", err
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	config["created"] = created
+	rootfs, _ := config["rootfs"].(map[string]interface{})
+	if rootfs == nil {
+		rootfs = map[string]interface{}{"type": "layers"}
+	}
+	diffIds, _ := rootfs["diff_ids"].([]interface{})
+	rootfs["diff_ids"] = append(diffIds, "sha256:"+diffId)
+	config["rootfs"] = rootfs
+	history, _ := config["history"].([]interface{})
+	config["history"] = append(history, map[string]interface{}{"created": created, "created_by": createdBy})
+
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	configSum := sha256.Sum256(configData)
+	configHex := hex.EncodeToString(configSum[:])
+	imageHash := configHex[:12]
+
+	// Put the image together where unfinished pulls go, so nothing shows
+	// up in the store unless it is complete.
+	stagingDir := imagesTmpPath + "/" + imageHash
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return "", err
+	}
+	lock, err := utils.MkdirLocked(stagingDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(stagingDir)
+	defer lock.Close()
+
+	// Layers are never written to, so the new image can share the base
+	// image's files and still outlive it.
+	for _, layer := range baseLayers {
+		if err := utils.CopyTree(imagesBasePath+"/"+baseHash+"/"+layer[:12], stagingDir+"/"+layer[:12], true); err != nil {
+			return "", fmt.Errorf("unable to link layer %s: %v", layer[:12], err)
+		}
+	}
+	layerDir := stagingDir + "/" + diffId[:12]
+	if err := os.Mkdir(layerDir, 0755); err != nil {
+		return "", err
+	}
+	if err := fillLayer(layerDir + "/fs"); err != nil {
+		return "", err
+	}
+	if err := os.Rename(layerTar, layerDir+"/layer.tar"); err != nil {
+		return "", err
+	}
+
+	mani := common.Manifest{{
+		Config:   configHex + ".json",
+		RepoTags: []string{imageName + ":" + tag},
+		Layers:   append(baseLayers, diffId+"/layer.tar"),
+	}}
+	maniData, err := json.Marshal(mani)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(stagingDir+"/"+imageHash+".json", maniData, 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(stagingDir+"/"+imageHash, configData, 0644); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(stagingDir, imagesBasePath+"/"+imageHash); err != nil {
+		return "", err
+	}
+	storeImageMetadata(imageName, tag, imageHash)
+	return imageHash, nil
+}
+
+// ImportImage creates a single layer image from a root file system
+// archive, plain or gzip compressed, read from r. It returns the hash of
+// the new image.
+func ImportImage(r io.Reader, ref string, changes []string) (string, error) {
+	imageName, tag := GetImageNameAndTag(ref)
+
+	reader := bufio.NewReader(r)
+	var src io.Reader = reader
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return "", err
+		}
+		defer gzipReader.Close()
+		src = gzipReader
+	}
+
+	layerFile, err := NewLayerFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(layerFile.Name())
+	defer layerFile.Close()
+	_, err = io.Copy(layerFile, src)
+	if err == nil {
+		err = layerFile.Sync()
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read archive: %v", err)
+	}
+
+	imageHash, err := CreateImage(imageName, tag, "", layerFile.Name(), func(dir string) error {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+		return tar.Untar(layerFile.Name(), dir, false)
+	}, changes, "container import")
+	if err != nil {
+		return "", err
+	}
+	events.Emit(events.TypeImage, "import", imageHash, map[string]string{"name": imageName + ":" + tag})
+	return imageHash, nil
+}
+
+// ApplyChanges applies Dockerfile-like instructions to the config of an
+// image. CMD takes a JSON array or a command for /bin/sh -c, ENV a
+// key=value pair; the instruction may be followed by a space or "=".
+func ApplyChanges(config map[string]interface{}, changes []string) error {
+	details, _ := config["config"].(map[string]interface{})
+	if details == nil {
+		details = map[string]interface{}{}
+		config["config"] = details
+	}
+
+	for _, change := range changes {
+		change = strings.TrimSpace(change)
+		i := strings.IndexAny(change, " =")
+		if i < 0 {
+			return fmt.Errorf("invalid change: %s", change)
+		}
+		instruction, value := strings.ToUpper(change[:i]), strings.TrimSpace(change[i+1:])
+
+		switch instruction {
+		case "CMD":
+			var cmd []string
+			if strings.HasPrefix(value, "[") {
+				if err := json.Unmarshal([]byte(value), &cmd); err != nil {
+					return fmt.Errorf("invalid CMD %s: %v", value, err)
+				}
+			} else {
+				cmd = []string{"/bin/sh", "-c", value}
+			}
+			details["Cmd"] = cmd
+
+		case "ENV":
+			var key string
+			if j := strings.IndexAny(value, " ="); j > 0 {
+				key, value = value[:j], strings.TrimSpace(value[j+1:])
+			} else {
+				return fmt.Errorf("invalid ENV %s, expected key=value", value)
+			}
+			env, _ := details["Env"].([]interface{})
+			var newEnv []interface{}
+			for _, entry := range env {
+				if s, ok := entry.(string); ok && strings.HasPrefix(s, key+"=") {
+					continue
+				}
+				newEnv = append(newEnv, entry)
+			}
+			details["Env"] = append(newEnv, key+"="+value)
+
+		default:
+			return fmt.Errorf("unsupported change %s, expected CMD or ENV", instruction)
+		}
+	}
+	return nil
+}
+
+// NewLayerFile creates a file in the temporary directory for a layer
+// archive to be written to before it is given to CreateImage. It is
+// locked against system prune until it is closed, so keep it open until
+// CreateImage returns.
+func NewLayerFile() (*os.File, error) {
+	file, err := utils.CreateTempLocked(imagesTmpPath, "layer-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
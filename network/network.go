@@ -6,9 +6,11 @@ import (
 	"log"
 	"math/rand"
 	"net"
+	"strings"
 
 	"github.com/sunweiwe/container/utils"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 	"golang.org/x/sys/unix"
 )
 
@@ -16,6 +18,24 @@ const (
 	nsMountBase = "/var/run/container/net-ns"
 )
 
+// HostVethName and ContainerVethName name the two ends of a container's
+// veth pair. Link names are limited to 15 bytes, so only the start of the
+// container id is used.
+func HostVethName(containerId string) string {
+	return "veth0_" + vethSuffix(containerId)
+}
+
+func ContainerVethName(containerId string) string {
+	return "veth1_" + vethSuffix(containerId)
+}
+
+func vethSuffix(containerId string) string {
+	if len(containerId) > 6 {
+		return containerId[:6]
+	}
+	return containerId
+}
+
 func createIPAddress() string {
 	byte1 := rand.Intn(254)
 	byte2 := rand.Intn(254)
@@ -23,8 +43,8 @@ func createIPAddress() string {
 }
 
 func SetUpVirtualEthOnHost(containerId string) error {
-	veth0 := "veth0_" + containerId[:6]
-	veth1 := "veth1_" + containerId[:6]
+	veth0 := HostVethName(containerId)
+	veth1 := ContainerVethName(containerId)
 
 	// veth pair
 	LinkAttrs := netlink.NewLinkAttrs()
@@ -40,11 +60,98 @@ func SetUpVirtualEthOnHost(containerId string) error {
 		return err
 	}
 
-	netlink.LinkSetUp(veth0Struct)
-	containerBridge, _ := netlink.LinkByName("container0")
+	if err := netlink.LinkSetUp(veth0Struct); err != nil {
+		return err
+	}
+	containerBridge, err := netlink.LinkByName("container0")
+	if err != nil {
+		return err
+	}
+
+	return netlink.LinkSetMaster(veth0Struct, containerBridge)
+}
 
-	netlink.LinkSetMaster(veth0Struct, containerBridge)
-	return nil
+// RemoveVirtualEthOnHost deletes the host side of the container's veth
+// pair. It is not an error if the link is already gone.
+func RemoveVirtualEthOnHost(containerId string) error {
+	link, err := netlink.LinkByName(HostVethName(containerId))
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	return netlink.LinkDel(link)
+}
+
+// ListVirtualEthOnHost returns the container id prefixes of the host side
+// veth links attached to the container0 bridge, as they appear in the
+// link names.
+func ListVirtualEthOnHost() ([]string, error) {
+	bridge, err := netlink.LinkByName("container0")
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []string
+	for _, link := range links {
+		attrs := link.Attrs()
+		if attrs.MasterIndex == bridge.Attrs().Index && strings.HasPrefix(attrs.Name, "veth0_") {
+			prefixes = append(prefixes, strings.TrimPrefix(attrs.Name, "veth0_"))
+		}
+	}
+	return prefixes, nil
+}
+
+// GetVirtualEthStats returns the bytes received and sent by the container
+// over its veth pair. They are read from the host end, where the
+// container's traffic shows up the other way round.
+func GetVirtualEthStats(containerId string) (uint64, uint64, error) {
+	link, err := netlink.LinkByName(HostVethName(containerId))
+	if err != nil {
+		return 0, 0, err
+	}
+	stats := link.Attrs().Statistics
+	if stats == nil {
+		return 0, 0, fmt.Errorf("no statistics for %s", link.Attrs().Name)
+	}
+	return stats.TxBytes, stats.RxBytes, nil
+}
+
+// GetContainerAddress returns the IP and MAC address of the container end
+// of the veth pair, as seen inside the container's network namespace.
+func GetContainerAddress(containerId string) (string, string, error) {
+	ns, err := netns.GetFromPath(nsMountBase + "/" + containerId)
+	if err != nil {
+		return "", "", err
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return "", "", err
+	}
+	defer handle.Delete()
+
+	link, err := handle.LinkByName(ContainerVethName(containerId))
+	if err != nil {
+		return "", "", err
+	}
+	addrs, err := handle.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return "", "", err
+	}
+	ip := ""
+	if len(addrs) > 0 {
+		ip = addrs[0].IP.String()
+	}
+	return ip, link.Attrs().HardwareAddr.String(), nil
 }
 
 // 生成固定的地址
@@ -101,7 +208,7 @@ func SetupContainerNetWorkInterface(containerId string) {
 	}
 
 	/* Set veth1 of the new container to the new network namespace */
-	veth1 := "veth1_" + containerId[:6]
+	veth1 := ContainerVethName(containerId)
 	veth1Link, err := netlink.LinkByName(veth1)
 	if err != nil {
 		log.Fatalf("Unable to fetch veth1: %v\n", err)
@@ -4,10 +4,28 @@ package tar
 import (
 	"archive/tar"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sunweiwe/container/utils"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Image layers mark deleted files with an empty .wh.<name> entry and
+	// directories whose lower contents are hidden with .wh..wh..opq.
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+
+	// overlayfs marks them with a 0/0 character device and this xattr.
+	overlayOpaqueXattr = "trusted.overlay.opaque"
 )
 
 func createReader(reader *os.File, zip bool) (*tar.Reader, error) {
@@ -26,16 +44,32 @@ func createReader(reader *os.File, zip bool) (*tar.Reader, error) {
 }
 
 func Untar(tarball string, target string, zip bool) error {
-	hardLinks := make(map[string]string)
 	reader, err := os.Open(tarball)
 	if err != nil {
 		return err
 	}
+	defer reader.Close()
 
 	tarReader, err := createReader(reader, zip)
 	if err != nil {
 		return err
 	}
+	return extract(tarReader, target, "", true)
+}
+
+// Extract unpacks the tar archive read from r into dir of the tree under
+// root. root is treated as the root directory: entries and the symlinks
+// on their way are resolved inside it, so the archive cannot write
+// anywhere else.
+func Extract(r io.Reader, root string, dir string) error {
+	return extract(tar.NewReader(r), root, dir, false)
+}
+
+// extract unpacks an archive, keeping ownership and modes. With layer
+// set, the whiteouts of an image layer are turned into the ones overlayfs
+// understands, so the result can be mounted as a lower directory.
+func extract(tarReader *tar.Reader, target string, dir string, layer bool) error {
+	hardLinks := make(map[string]string)
 
 	for {
 		header, err := tarReader.Next()
@@ -45,41 +79,62 @@ func Untar(tarball string, target string, zip bool) error {
 			return err
 		}
 
-		path := filepath.Join(target, header.Name)
+		path, err := utils.ResolveInRoot(target, filepath.Join(dir, header.Name), false)
+		if err != nil {
+			return err
+		}
 		info := header.FileInfo()
+		mode := uint32(header.Mode & 07777)
+
+		/* Ensure any missing directories are created */
+		if path != target {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+		}
+		// Whatever is in the way is replaced, never written through. That
+		// includes a symlink where the archive has a directory, which
+		// would otherwise lead out of target.
+		if existing, err := os.Lstat(path); err == nil && (header.Typeflag != tar.TypeDir || !existing.IsDir()) {
+			if existing.IsDir() {
+				return fmt.Errorf("cannot overwrite directory %s with a non-directory", header.Name)
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+
+		base := filepath.Base(header.Name)
+		if layer && header.Typeflag == tar.TypeReg && strings.HasPrefix(base, whiteoutPrefix) {
+			parent := filepath.Dir(path)
+			if base == whiteoutOpaque {
+				if err := unix.Lsetxattr(parent, overlayOpaqueXattr, []byte("y"), 0); err != nil {
+					return err
+				}
+			} else if err := unix.Mknod(filepath.Join(parent, strings.TrimPrefix(base, whiteoutPrefix)), unix.S_IFCHR, 0); err != nil {
+				return err
+			}
+			continue
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err = os.MkdirAll(path, info.Mode()); err != nil {
+			if err = os.Mkdir(path, info.Mode()); err != nil && !os.IsExist(err) {
 				return err
 			}
-			continue
 
 		case tar.TypeLink:
 			/* Store details of hard links, which we process finally */
-			linkPath := filepath.Join(target, header.Linkname)
-			linkPath2 := filepath.Join(target, header.Name)
-			hardLinks[linkPath2] = linkPath
+			hardLinks[filepath.Join(dir, header.Name)] = filepath.Join(dir, header.Linkname)
 			continue
 
 		case tar.TypeSymlink:
-			linkPath := filepath.Join(target, header.Name)
-			if err := os.Symlink(header.Linkname, linkPath); err != nil {
-				if os.IsExist(err) {
-					continue
-				}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
 			}
-			continue
 
 		case tar.TypeReg:
-			/* Ensure any missing directories are created */
-			if _, err := os.Stat(filepath.Dir(path)); os.IsNotExist(err) {
-				os.MkdirAll(filepath.Dir(path), 0755)
-			}
-			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if os.IsExist(err) {
-				continue
-			}
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|unix.O_NOFOLLOW, info.Mode())
 			if err != nil {
 				return err
 			}
@@ -89,16 +144,177 @@ func Untar(tarball string, target string, zip bool) error {
 				return err
 			}
 
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			fileType := map[byte]uint32{tar.TypeChar: unix.S_IFCHR, tar.TypeBlock: unix.S_IFBLK, tar.TypeFifo: unix.S_IFIFO}
+			dev := unix.Mkdev(uint32(header.Devmajor), uint32(header.Devminor))
+			if err := unix.Mknod(path, fileType[header.Typeflag]|mode, int(dev)); err != nil {
+				return err
+			}
+
 		default:
 			log.Printf("Warning: File type %d unhandled by untar function!\n", header.Typeflag)
+			continue
 		}
+
+		if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeSymlink {
+			// After the chown, which clears the setuid and setgid bits.
+			if err := chmodNoFollow(path, mode); err != nil {
+				return err
+			}
+		}
+		times := []unix.Timespec{unix.NsecToTimespec(header.ModTime.UnixNano()), unix.NsecToTimespec(header.ModTime.UnixNano())}
+		unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
 	}
 	/* To create hard links the targets must exist, so we do this finally */
-	for k, v := range hardLinks {
-		if err := os.Link(v, k); err != nil {
+	for name, linkName := range hardLinks {
+		path, err := utils.ResolveInRoot(target, name, false)
+		if err != nil {
+			return err
+		}
+		linkPath, err := utils.ResolveInRoot(target, linkName, false)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(linkPath, path); err != nil {
 			return err
 		}
 	}
 	return nil
 
 }
+
+// chmodNoFollow changes the mode of path, which must not be a symlink.
+// Linux has no lchmod, so the file is opened without following a symlink
+// and changed through its descriptor.
+func chmodNoFollow(path string, mode uint32) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return err
+	}
+	if stat.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return fmt.Errorf("refusing to change the mode of symlink %s", path)
+	}
+	return unix.Chmod("/proc/self/fd/"+strconv.Itoa(fd), mode)
+}
+
+// Tar writes the tree under dir to w as a tar archive, keeping ownership,
+// modes and hard links. With layer set, dir is an overlayfs upper
+// directory and its whiteouts are written the way image layers expect.
+func Tar(w io.Writer, dir string, layer bool) error {
+	tarWriter := tar.NewWriter(w)
+	if err := addTree(tarWriter, dir, "", layer); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// TarPath writes the file or tree at path to w as a tar archive whose top
+// entry is called name.
+func TarPath(w io.Writer, path string, name string) error {
+	tarWriter := tar.NewWriter(w)
+	if err := addTree(tarWriter, path, name, false); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// addTree adds the tree at root to the archive under prefix, leaving out
+// root itself if prefix is empty.
+func addTree(tarWriter *tar.Writer, root string, prefix string, layer bool) error {
+	hardLinks := make(map[uint64]string)
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			if len(prefix) == 0 {
+				return nil
+			}
+			name = prefix
+		} else {
+			name = filepath.Join(prefix, name)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+
+		if layer && info.Mode()&os.ModeCharDevice != 0 && stat.Rdev == 0 {
+			return writeWhiteout(tarWriter, filepath.Join(filepath.Dir(name), whiteoutPrefix+info.Name()), info)
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		// The names of the host's users mean nothing in the container.
+		header.Uname, header.Gname = "", ""
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+
+		if header.Typeflag == tar.TypeReg && stat.Nlink > 1 {
+			if first, ok := hardLinks[stat.Ino]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+			} else {
+				hardLinks[stat.Ino] = name
+			}
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tarWriter, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		if layer && info.IsDir() && isOpaque(path) {
+			return writeWhiteout(tarWriter, filepath.Join(name, whiteoutOpaque), info)
+		}
+		return nil
+	})
+}
+
+func writeWhiteout(tarWriter *tar.Writer, name string, info os.FileInfo) error {
+	return tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		ModTime:  info.ModTime(),
+	})
+}
+
+func isOpaque(path string) bool {
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(path, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
@@ -0,0 +1,150 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	mode     int64
+	body     string
+}
+
+func archive(t *testing.T, entries []entry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     e.mode,
+			Size:     int64(len(e.body)),
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// outside returns a directory next to root holding a file, and checks on
+// cleanup that neither was touched.
+func outside(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("host"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		info, err := os.Stat(dir)
+		if err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("mode of outside directory changed: %v %v", info.Mode(), err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil || string(data) != "host" {
+			t.Errorf("outside file changed: %q %v", data, err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("outside directory has %d entries, want 1", len(entries))
+		}
+	})
+	return dir
+}
+
+func TestExtractDotDot(t *testing.T) {
+	root := t.TempDir()
+	out := outside(t)
+	rel, _ := filepath.Rel(root, filepath.Join(out, "x"))
+
+	err := Extract(archive(t, []entry{
+		{name: rel, typeflag: tar.TypeReg, mode: 0644, body: "x"},
+		{name: "../../../x", typeflag: tar.TypeReg, mode: 0644, body: "x"},
+	}), root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "x")); err != nil {
+		t.Errorf("entry with .. not kept inside root: %v", err)
+	}
+}
+
+func TestExtractThroughAbsoluteSymlink(t *testing.T) {
+	root := t.TempDir()
+	out := outside(t)
+
+	err := Extract(archive(t, []entry{
+		{name: "link", typeflag: tar.TypeSymlink, linkname: out},
+		{name: "link/file", typeflag: tar.TypeReg, mode: 0644, body: "pwned"},
+		{name: "link/new", typeflag: tar.TypeReg, mode: 0644, body: "pwned"},
+	}), root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, out, "file"))
+	if err != nil || string(data) != "pwned" {
+		t.Errorf("file behind symlink not written inside root: %q %v", data, err)
+	}
+}
+
+func TestExtractOverExistingSymlink(t *testing.T) {
+	root := t.TempDir()
+	out := outside(t)
+	if err := os.Symlink(out, filepath.Join(root, "data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(out, "file"), filepath.Join(root, "file")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Extract(archive(t, []entry{
+		{name: "data/", typeflag: tar.TypeDir, mode: 0700},
+		{name: "file", typeflag: tar.TypeReg, mode: 0600, body: "pwned"},
+	}), root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(filepath.Join(root, "data"))
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Errorf("data is not a 0700 directory: %v %v", info, err)
+	}
+	if info, err := os.Lstat(filepath.Join(root, "file")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("file is not a regular file: %v %v", info, err)
+	}
+}
+
+func TestExtractIntoDirThroughSymlink(t *testing.T) {
+	root := t.TempDir()
+	out := outside(t)
+	if err := os.Symlink(out, filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Extract(archive(t, []entry{
+		{name: "./", typeflag: tar.TypeDir, mode: 0700},
+		{name: "new", typeflag: tar.TypeReg, mode: 0644, body: "x"},
+	}), root, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "dir", "new")); err != nil {
+		t.Errorf("entry not written inside root: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+//Package tty pseudo-terminal helpers
+package tty
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+func IsTerminal(fd uintptr) bool {
+	_, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	return err == nil
+}
+
+// MakeRaw puts the terminal into raw mode, the way cfmakeraw(3) does, and
+// returns a function that puts it back the way it was.
+func MakeRaw(fd uintptr) (func() error, error) {
+	termios, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	old := *termios
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return unix.IoctlSetTermios(int(fd), unix.TCSETS, &old)
+	}, nil
+}
+
+// Relay puts the terminal fd into raw mode, so keystrokes pass through it
+// untouched, and calls resize with its size now and whenever it changes.
+// The returned function stops relaying and restores the terminal.
+func Relay(fd uintptr, resize func(ws *unix.Winsize)) (func(), error) {
+	restoreTerminal, err := MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set terminal to raw mode: %v", err)
+	}
+
+	update := func() {
+		if ws, err := GetWinsize(fd); err == nil {
+			resize(ws)
+		}
+	}
+	update()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	go func() {
+		for range winch {
+			update()
+		}
+	}()
+
+	return func() {
+		signal.Stop(winch)
+		close(winch)
+		restoreTerminal()
+	}, nil
+}
+
+func GetWinsize(fd uintptr) (*unix.Winsize, error) {
+	return unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ)
+}
+
+func SetWinsize(fd uintptr, ws *unix.Winsize) error {
+	return unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, ws)
+}
+
+// OpenPty allocates a new pseudo-terminal from the devpts instance mounted
+// at ptsDir. Passing the container's /dev/pts gives a terminal that
+// belongs to the container, not to the host.
+func OpenPty(ptsDir string) (*os.File, *os.File, error) {
+	master, err := os.OpenFile(ptsDir+"/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unable to unlock pty: %v", err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unable to get pty number: %v", err)
+	}
+	slave, err := os.OpenFile(ptsDir+"/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// SendFd passes an open file over a unix socket.
+func SendFd(socket *os.File, file *os.File) error {
+	return unix.Sendmsg(int(socket.Fd()), []byte(file.Name()), unix.UnixRights(int(file.Fd())), nil, 0)
+}
+
+// RecvFd receives a file sent with SendFd.
+func RecvFd(socket *os.File) (*os.File, error) {
+	name := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := unix.Recvmsg(int(socket.Fd()), name, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 && oobn == 0 {
+		return nil, fmt.Errorf("socket closed before a file was received")
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("expected one control message, got %d", len(msgs))
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		return nil, fmt.Errorf("expected one file descriptor, got %d", len(fds))
+	}
+	return os.NewFile(uintptr(fds[0]), string(name[:n])), nil
+}
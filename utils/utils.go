@@ -3,11 +3,17 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/sunweiwe/container/common"
+	"golang.org/x/sys/unix"
 )
 
 func ParseManifest(manifestPath string, mani *common.Manifest) error {
@@ -42,6 +48,244 @@ func CopyFile(src, dst string) error {
 	return nil
 }
 
+// CopyTree copies the tree under src to dst, which must not exist yet,
+// keeping ownership, modes, device nodes and the overlayfs opaque xattr,
+// so that an overlay upper or lower directory stays one. With link set,
+// files are hard linked rather than copied; that is only safe for trees
+// nobody writes to, such as image layers.
+func CopyTree(src string, dst string, link bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		stat := info.Sys().(*syscall.Stat_t)
+
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			value := make([]byte, 1)
+			if n, err := unix.Lgetxattr(path, "trusted.overlay.opaque", value); err == nil && n == 1 {
+				if err := unix.Lsetxattr(target, "trusted.overlay.opaque", value, 0); err != nil {
+					return err
+				}
+			}
+		case link:
+			return os.Link(path, target)
+		case info.Mode()&os.ModeSymlink != 0:
+			dest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(dest, target); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := CopyFile(path, target); err != nil {
+				return err
+			}
+		default:
+			if err := unix.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		// After the chown, which clears the setuid and setgid bits.
+		return unix.Chmod(target, stat.Mode&07777)
+	})
+}
+
+// ResolveInRoot returns the host path of path in the tree under root,
+// following symlinks as if root were /, so that neither ".." nor a
+// symlink can lead out of it. The last element is only followed if
+// followLast is set. Elements that do not exist are taken as they are.
+func ResolveInRoot(root string, path string, followLast bool) (string, error) {
+	resolved := ""
+	remaining := path
+	links := 0
+	for len(remaining) > 0 {
+		part := remaining
+		if i := strings.IndexByte(remaining, '/'); i >= 0 {
+			part, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			remaining = ""
+		}
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved = filepath.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		if len(remaining) == 0 && !followLast {
+			resolved = next
+			break
+		}
+
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", path)
+		}
+		dest, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			resolved = ""
+		}
+		remaining = dest + "/" + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// LockPath takes an exclusive lock on the file or directory at path,
+// waiting for it if need be. The lock is held until the returned file is
+// closed. Writers hold it on what they are still working on so that
+// cleanups can tell it apart from leftovers, see TryLockPath. A cleanup
+// also holds it on the directory the entries are in, so that entries
+// made with MkdirLocked or CreateTempLocked are never seen unlocked.
+func LockPath(path string) (*os.File, error) {
+	return lockPath(path, unix.LOCK_EX)
+}
+
+// TryLockPath is LockPath without the waiting. It returns nil, and no
+// error, if somebody else holds the lock.
+func TryLockPath(path string) (*os.File, error) {
+	file, err := lockPath(path, unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return nil, nil
+	}
+	return file, err
+}
+
+func lockPath(path string, how int) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// MkdirLocked creates the directory path, if it does not exist yet, and
+// returns it locked as LockPath does.
+func MkdirLocked(path string, perm os.FileMode) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	for {
+		var lock *os.File
+		err := withParentLocked(path, func() error {
+			if err := os.Mkdir(path, perm); err != nil && !os.IsExist(err) {
+				return err
+			}
+			var err error
+			lock, err = TryLockPath(path)
+			return err
+		})
+		if err != nil || lock != nil {
+			return lock, err
+		}
+
+		// Somebody else is working on it. Wait without keeping cleanups
+		// out, and start over if they removed it in the meantime.
+		if lock, err = LockPath(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if lock != nil {
+			if sameFile(lock, path) {
+				return lock, nil
+			}
+			lock.Close()
+		}
+	}
+}
+
+// CreateTempLocked is os.CreateTemp, returning the new file locked as
+// LockPath does.
+func CreateTempLocked(dir string, pattern string) (*os.File, error) {
+	var file *os.File
+	err := withParentLocked(filepath.Join(dir, pattern), func() error {
+		var err error
+		if file, err = os.CreateTemp(dir, pattern); err != nil {
+			return err
+		}
+		if err := unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+		return nil
+	})
+	return file, err
+}
+
+// withParentLocked calls fn with a shared lock on the directory path is
+// in, which keeps a cleanup holding it with LockPath out.
+func withParentLocked(path string, fn func() error) error {
+	parent, err := lockPath(filepath.Dir(path), unix.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	return fn()
+}
+
+func sameFile(file *os.File, path string) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(info, current)
+}
+
+// ParseSignal accepts a signal as a name, with or without the SIG prefix,
+// or as a number.
+func ParseSignal(sig string) (unix.Signal, error) {
+	if num, err := strconv.Atoi(sig); err == nil {
+		if num <= 0 || num > 64 {
+			return 0, fmt.Errorf("invalid signal: %s", sig)
+		}
+		return unix.Signal(num), nil
+	}
+	name := strings.ToUpper(sig)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if num := unix.SignalNum(name); num != 0 {
+		return num, nil
+	}
+	return 0, fmt.Errorf("invalid signal: %s", sig)
+}
+
 func DoOrDieWithMessage(err error, msg string) {
 	if err != nil {
 		log.Fatalf("Fatal error: %s: %v\n", msg, err)
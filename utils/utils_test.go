@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"abs":   "/etc",
+		"rel":   "../../../../etc",
+		"up":    "../../..",
+		"loop1": "loop2",
+		"loop2": "loop1",
+	}
+	for name, dest := range links {
+		if err := os.Symlink(dest, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path       string
+		followLast bool
+		want       string
+	}{
+		{"/etc/passwd", false, "etc/passwd"},
+		{"../../etc/passwd", false, "etc/passwd"},
+		{"/abs/passwd", false, "etc/passwd"},
+		{"/rel/passwd", false, "etc/passwd"},
+		{"/up/x", false, "x"},
+		{"/up/../../x", false, "x"},
+		{"/abs", false, "abs"},
+		{"/abs", true, "etc"},
+		{"/up", true, ""},
+		{"/missing/../etc", false, "etc"},
+	}
+	for _, test := range tests {
+		got, err := ResolveInRoot(root, test.path, test.followLast)
+		if err != nil {
+			t.Errorf("ResolveInRoot(%q, %v): %v", test.path, test.followLast, err)
+			continue
+		}
+		if want := filepath.Join(root, test.want); got != want {
+			t.Errorf("ResolveInRoot(%q, %v) = %q, want %q", test.path, test.followLast, got, want)
+		}
+	}
+
+	if _, err := ResolveInRoot(root, "/loop1/x", false); err == nil {
+		t.Errorf("ResolveInRoot through a symlink loop did not fail")
+	}
+}
+
+func TestMkdirLocked(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pull")
+
+	lock, err := MkdirLocked(path, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, err := TryLockPath(path); err != nil || other != nil {
+		t.Fatalf("TryLockPath on a locked directory = %v, %v", other, err)
+	}
+	lock.Close()
+
+	if _, err := TryLockPath(filepath.Join(dir, "gone")); !os.IsNotExist(err) {
+		t.Fatalf("TryLockPath on a missing entry = %v, want not exist", err)
+	}
+
+	// A cleanup holding the directory keeps new entries from being made.
+	cleanup, err := LockPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := make(chan *os.File)
+	go func() {
+		lock, err := MkdirLocked(filepath.Join(dir, "new"), 0755)
+		if err != nil {
+			t.Error(err)
+		}
+		created <- lock
+	}()
+	select {
+	case <-created:
+		t.Fatal("MkdirLocked did not wait for the cleanup")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Fatalf("directory made while the cleanup held the lock: %v", err)
+	}
+	cleanup.Close()
+	if lock := <-created; lock != nil {
+		lock.Close()
+	}
+}